@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+)
+
+// Exports are tar.gz archives holding a manifest followed by one JSON file
+// per record, named after the record key below dataDir. The extension keeps
+// a group file from clashing with the directory of its label index.
+const (
+	exportFormat  = "configstore-export"
+	exportVersion = 1
+	manifestName  = "manifest.json"
+	dataDir       = "data/"
+	dataExt       = ".json"
+)
+
+// Limits on imports. A record is written as a single value, which the store
+// takes up to 512 KiB of.
+const (
+	maxImportSize = 64 << 20
+	maxRecordSize = 512 << 10
+)
+
+// errTooLarge is returned by readExport for an archive entry larger than
+// maxRecordSize.
+var errTooLarge = errors.New("archive entry too large")
+
+// exportManifest starts an export. The records that follow are written as
+// they are read, so the manifest can't count them.
+type exportManifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (ts *Service) exportHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("exportHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling export at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	now := time.Now().UTC()
+	export := &exportWriter{w: w, now: now}
+	err := ts.store.Export(ctx, export.write)
+	if err == nil {
+		err = export.close()
+	}
+	if err != nil && !export.started {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not export store")
+		return
+	}
+	if err != nil {
+		// Part of the archive has been sent already, so all that can be
+		// done is to cut the response short.
+		tracer.LogError(span, err)
+	}
+}
+
+// exportWriter writes an export to an HTTP response as the records are
+// read. Nothing is sent before the first record, so that a store that can't
+// be read at all is still answered with an error status.
+type exportWriter struct {
+	w       http.ResponseWriter
+	now     time.Time
+	started bool
+	gz      *gzip.Writer
+	tw      *tar.Writer
+}
+
+// start sends the headers of the response and the manifest.
+func (e *exportWriter) start() error {
+	e.started = true
+
+	e.w.Header().Set("Content-Type", "application/gzip")
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "configstore-"+e.now.Format("20060102T150405Z")+".tar.gz"))
+
+	e.gz = gzip.NewWriter(e.w)
+	e.tw = tar.NewWriter(e.gz)
+
+	manifest, err := json.Marshal(&exportManifest{
+		Format:    exportFormat,
+		Version:   exportVersion,
+		CreatedAt: e.now,
+	})
+	if err != nil {
+		return err
+	}
+	return writeTarFile(e.tw, manifestName, manifest, e.now)
+}
+
+func (e *exportWriter) write(record *cs.Record) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	return writeTarFile(e.tw, dataDir+record.Key+dataExt, record.Value, e.now)
+}
+
+// close ends the archive, starting it first if the store held no records.
+func (e *exportWriter) close() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if err := e.tw.Close(); err != nil {
+		return err
+	}
+	return e.gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
+
+func (ts *Service) importHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("importHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling import at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	mode := req.URL.Query().Get("conflict")
+	if mode == "" {
+		mode = cs.ImportFail
+	}
+
+	if req.ContentLength > maxImportSize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Archive is larger than %d bytes", maxImportSize))
+		return
+	}
+
+	records, err := readExport(ctx, http.MaxBytesReader(w, req.Body, maxImportSize))
+	if errors.Is(err, errTooLarge) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, codeTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	result, err := ts.store.Import(ctx, records, mode)
+	if err != nil {
+		// Records written before the failure stay, and the client needs to
+		// know about them before trying again.
+		status, code := storeStatus(err)
+		detail := storeDetail(err, "Could not import records")
+		if result != nil && result.Created+result.Overwritten > 0 {
+			detail = fmt.Sprintf("%s; %d records were created and %d overwritten before the failure", detail, result.Created, result.Overwritten)
+		}
+		writeProblem(w, status, code, detail)
+		return
+	}
+
+	renderJSON(ctx, w, result, "")
+}
+
+func readExport(ctx context.Context, r io.Reader) ([]*cs.Record, error) {
+	span := tracer.StartSpanFromContext(ctx, "readExport")
+	defer span.Finish()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	var manifest *exportManifest
+	var records []*cs.Record
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		if header.Size > maxRecordSize {
+			return nil, fmt.Errorf("%w: %q holds %d bytes, at most %d are allowed", errTooLarge, header.Name, header.Size, maxRecordSize)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		if manifest == nil {
+			if header.Name != manifestName {
+				return nil, errors.New("Archive must start with " + manifestName)
+			}
+
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+
+			if manifest.Format != exportFormat || manifest.Version < 1 || manifest.Version > exportVersion {
+				return nil, fmt.Errorf("Unsupported archive %s version %d", manifest.Format, manifest.Version)
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasPrefix(header.Name, dataDir) || !strings.HasSuffix(header.Name, dataExt) {
+			return nil, fmt.Errorf("Unexpected archive entry %q", header.Name)
+		}
+
+		key := strings.TrimSuffix(strings.TrimPrefix(header.Name, dataDir), dataExt)
+		records = append(records, &cs.Record{Key: key, Value: data})
+	}
+
+	if manifest == nil {
+		return nil, errors.New("Archive has no " + manifestName)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestExportCanBeImported(t *testing.T) {
+	source, _ := newTestService(t)
+	ctx := context.Background()
+
+	for _, ver := range []string{"v1", "v2"} {
+		if _, err := source.store.UpdateConfigVersion(ctx, &cs.Config{ID: "c", Version: ver, Entries: cs.Entries{"k": ver}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group, err := source.store.CreateGroup(ctx, &cs.Group{Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: map[string]string{"env": "dev"}, Entries: cs.Entries{"k": "v"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := serve(source.exportHandler, httptest.NewRequest(http.MethodGet, "/admin/export", nil), nil)
+	if export.Code != http.StatusOK || export.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("export answered %d %s", export.Code, export.Header().Get("Content-Type"))
+	}
+
+	records, err := readExport(ctx, bytes.NewReader(export.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Errorf("export holds %d records, want 2 configs, a group and its label index", len(records))
+	}
+
+	target, _ := newTestService(t)
+	w := serve(target.importHandler, httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(export.Body.Bytes())), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import answered %d: %s", w.Code, w.Body)
+	}
+	var result cs.ImportResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 4 {
+		t.Errorf("import created %d records, want 4", result.Created)
+	}
+
+	members, err := target.store.FindLabels(ctx, group.ID, "v1", map[string]string{"env": "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 {
+		t.Errorf("imported group has %d members labelled env=dev, want 1", len(members))
+	}
+}
+
+func TestExportOfEmptyStoreHasManifest(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	w := serve(ts.exportHandler, httptest.NewRequest(http.MethodGet, "/admin/export", nil), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export answered %d", w.Code)
+	}
+	records, err := readExport(context.Background(), w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("export of an empty store holds %d records", len(records))
+	}
+}
+
+func TestExportFailsBeforeSendingAnything(t *testing.T) {
+	ts, fake := newTestService(t)
+	fake.Close()
+
+	w := serve(ts.exportHandler, httptest.NewRequest(http.MethodGet, "/admin/export", nil), nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("export of an unreachable store answered %d, want 500", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("export of an unreachable store sent %s", ct)
+	}
+}
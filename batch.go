@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/format"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+)
+
+type batchRequest struct {
+	Operations []*cs.BatchOp `json:"operations" yaml:"operations" toml:"operations"`
+}
+
+type batchResult struct {
+	*cs.BatchResult
+	Status int    `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Atomic         bool           `json:"atomic"`
+	Results        []*batchResult `json:"results"`
+	IdempotencyKey string         `json:"idempotencyKey,omitempty"`
+}
+
+func (ts *Service) batchHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("batchHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling batch at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	contentType := req.Header.Get("Content-Type")
+	requestId := idempotencyKey(req)
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
+		return
+	}
+
+	var body batchRequest
+	if err := format.Decode(req.Body, f, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(body.Operations) == 0 {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Operations are required")
+		return
+	}
+
+	// Every operation is checked before anything is written.
+	for i, op := range body.Operations {
+		if !validBatchOp(op) {
+			writeProblem(w, http.StatusUnprocessableEntity, codeValidation, fmt.Sprintf("Invalid operation %d", i))
+			return
+		}
+	}
+
+	results, atomic := ts.store.Batch(cs.WithRequestId(ctx, requestId), body.Operations)
+	for _, result := range results {
+		if errors.Is(result.Err, cs.ErrDuplicate) {
+			ts.replayBatch(ctx, w, requestId)
+			return
+		}
+	}
+
+	resp := &batchResponse{Atomic: atomic, Results: make([]*batchResult, len(results))}
+	status := http.StatusOK
+	for i, result := range results {
+		resp.Results[i] = &batchResult{BatchResult: result, Status: http.StatusOK}
+		if result.Op == cs.OpCreate {
+			resp.Results[i].Status = http.StatusCreated
+		}
+
+		if result.Err != nil {
+			resp.Results[i].Status, resp.Results[i].Code = storeStatus(result.Err)
+			resp.Results[i].Error = result.Err.Error()
+			status = http.StatusMultiStatus
+		}
+	}
+
+	if status == http.StatusOK || !atomic {
+		resp.IdempotencyKey = requestId
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	renderJSON(ctx, w, resp, "")
+}
+
+// replayBatch answers a batch whose idempotency key was already used with
+// the operations the batch that used it first applied.
+func (ts *Service) replayBatch(ctx context.Context, w http.ResponseWriter, key string) {
+	span := tracer.StartSpanFromContext(ctx, "replayBatch")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	request, err := ts.store.FindRequest(childCtx, key)
+	if err != nil {
+		writeStoreError(w, err, "Could not find request")
+		return
+	}
+	if request == nil {
+		writeProblem(w, http.StatusConflict, codeDuplicate, "Request has been already sent")
+		return
+	}
+
+	resp := &batchResponse{Atomic: !request.Partial, Results: []*batchResult{}, IdempotencyKey: key}
+	for _, change := range request.Changes {
+		result := &batchResult{
+			BatchResult: &cs.BatchResult{Op: change.Operation, Resource: change.Resource, ID: change.ID, Version: change.Version},
+			Status:      http.StatusOK,
+		}
+		if change.Operation == cs.OpCreate {
+			result.Status = http.StatusCreated
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	renderJSON(ctx, w, resp, "")
+}
+
+// validBatchOp reports whether op carries what its kind of operation needs.
+// Versions are always required, IDs only to change existing items.
+func validBatchOp(op *cs.BatchOp) bool {
+	if op == nil || op.Version == "" {
+		return false
+	}
+
+	switch op.Op {
+	case cs.OpCreate:
+	case cs.OpUpdate, cs.OpDelete:
+		if op.ID == "" {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if op.Op == cs.OpDelete {
+		return op.Resource == cs.ResourceConfig || op.Resource == cs.ResourceGroup
+	}
+
+	switch op.Resource {
+	case cs.ResourceConfig:
+		return op.Entries != nil
+	case cs.ResourceGroup:
+		return op.Configs != nil && validGroupConfigs(op.Configs)
+	}
+	return false
+}
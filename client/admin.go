@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// BatchResult is the outcome of one operation of a batch. Status is the
+// response status the operation would have had on its own, and Code the
+// error code of a failed operation, see configstore.FromCode.
+type BatchResult struct {
+	Op       string `json:"op"`
+	Resource string `json:"resource"`
+	ID       string `json:"id,omitempty"`
+	Version  string `json:"version"`
+	Status   int    `json:"status"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResponse reports the outcome of a batch. Atomic batches are applied
+// completely or not at all.
+type BatchResponse struct {
+	Atomic         bool           `json:"atomic"`
+	Results        []*BatchResult `json:"results"`
+	IdempotencyKey string         `json:"idempotencyKey,omitempty"`
+}
+
+// Snapshot describes a snapshot kept by the server.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Batch applies ops and reports the result of each. A batch where some
+// operations failed is not an error; their results say why.
+func (c *Client) Batch(ctx context.Context, ops []*cs.BatchOp) (*BatchResponse, error) {
+	r, err := newRequest(http.MethodPost, "/batch").withJSON(map[string]interface{}{"operations": ops})
+	if err != nil {
+		return nil, err
+	}
+	r.idempotent = true
+
+	resp := &BatchResponse{}
+	_, err = c.call(ctx, "Batch", r, resp)
+	return resp, err
+}
+
+// Export returns a tar.gz archive of every config, group and label index record.
+// The caller closes it.
+func (c *Client) Export(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, "Export", newRequest(http.MethodGet, "/admin/export"))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Import loads an archive written by Export. The mode decides what happens
+// to records that already exist: configstore.ImportFail, ImportSkip or
+// ImportOverwrite, which only overwrites drafts.
+func (c *Client) Import(ctx context.Context, archive io.Reader, mode string) (*cs.ImportResult, error) {
+	body, err := io.ReadAll(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newRequest(http.MethodPost, "/admin/import")
+	r.body = body
+	r.contentType = "application/gzip"
+	if mode != "" {
+		r.query = url.Values{"conflict": {mode}}
+	}
+
+	result := &cs.ImportResult{}
+	_, err = c.call(ctx, "Import", r, result)
+	return result, err
+}
+
+func (c *Client) CreateSnapshot(ctx context.Context) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	_, err := c.createResource(ctx, "CreateSnapshot", newRequest(http.MethodPost, "/admin/snapshot"), snapshot)
+	return snapshot, err
+}
+
+func (c *Client) GetSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	_, err := c.call(ctx, "GetSnapshot", newRequest(http.MethodGet, "/admin/snapshot/%s", name), snapshot)
+	return snapshot, err
+}
+
+// ListSnapshots returns the snapshots kept by the server, oldest first.
+func (c *Client) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	_, err := c.call(ctx, "ListSnapshots", newRequest(http.MethodGet, "/admin/snapshot"), &snapshots)
+	return snapshots, err
+}
+
+// RestoreSnapshot replaces the whole store with the snapshot name.
+func (c *Client) RestoreSnapshot(ctx context.Context, name string) (*cs.RestoreMarker, error) {
+	marker := &cs.RestoreMarker{}
+	_, err := c.call(ctx, "RestoreSnapshot", newRequest(http.MethodPost, "/admin/snapshot/%s/restore", name), marker)
+	return marker, err
+}
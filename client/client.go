@@ -0,0 +1,373 @@
+// Package client is a Go client for the config store REST API. Writes are
+// sent with an idempotency key so that they can be retried safely, calls are
+// traced with the span found in their context, and WatchConfig and
+// WatchGroup follow a version as it changes.
+//
+// The /subscribe WebSocket and the /metrics routes are left to WebSocket and
+// Prometheus clients; Events covers the same changes as /subscribe.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+
+	idempotencyHeader = "X-Idempotency-Key"
+)
+
+// ErrDuplicate is returned for a write whose idempotency key the server has
+// already seen. A write retried under the same key is normally answered with
+// what the first attempt created; it fails with ErrDuplicate when that can't
+// be told again, e.g. because it was deleted since.
+var ErrDuplicate = errors.New("configstore: request has already been handled")
+
+// Error is returned for responses with an error status. Code is the
+// machine-readable code of the problem the server reported, if any. Error
+// unwraps to the store error the code, or else the status, stands for, so
+// that errors.Is(err, configstore.ErrNotFound) works as it does on the
+// server.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("configstore: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	if err := cs.FromCode(e.Code); err != nil {
+		return err
+	}
+
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return cs.ErrNotFound
+	case http.StatusConflict:
+		return cs.ErrConflict
+	case http.StatusPreconditionFailed:
+		return cs.ErrPreconditionFailed
+	case http.StatusServiceUnavailable:
+		return cs.ErrUnavailable
+	}
+	return nil
+}
+
+// Created identifies what a write created, along with the idempotency key
+// the write was recorded under. Location is the path it is served at. Index
+// is the store index of the config or group version written, for
+// conditional writes, and 0 for other resources.
+type Created struct {
+	ID             string
+	Version        string
+	Index          uint64
+	IdempotencyKey string
+	Location       string
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests with h instead of http.DefaultClient. Watch
+// calls block for minutes, so h should not have a shorter timeout.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithRetries sets how often a failed call is retried. Only calls that are
+// safe to repeat are retried, and only on network errors, 429 and 5xx.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with
+// every further retry.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = d
+	}
+}
+
+// New returns a client of the API served at baseURL, e.g.
+// "http://localhost:8000".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    http.DefaultClient,
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes the write called with ctx use key instead of a
+// generated one, e.g. to carry a key over from an earlier process.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+func idempotencyKeyFrom(ctx context.Context) string {
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok && key != "" {
+		return key
+	}
+	return uuid.New().String()
+}
+
+// request is a call to the API. Bodies are kept in memory so that they can
+// be sent again.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+	// idempotent requests are sent with an idempotency key, which makes
+	// them safe to retry.
+	idempotent bool
+}
+
+func newRequest(method string, path string, elem ...string) *request {
+	escaped := make([]interface{}, len(elem))
+	for i, e := range elem {
+		escaped[i] = url.PathEscape(e)
+	}
+	return &request{method: method, path: fmt.Sprintf(path, escaped...), header: make(http.Header)}
+}
+
+func (r *request) withJSON(v interface{}) (*request, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	r.body = body
+	r.contentType = "application/json"
+	return r, nil
+}
+
+// withMatch makes a write go through only if the version still has the
+// given index. Index 0 sends no precondition.
+func (r *request) withMatch(index uint64) *request {
+	if index != 0 {
+		r.header.Set("If-Match", strconv.Quote(strconv.FormatUint(index, 10)))
+	}
+	return r
+}
+
+func (r *request) retryable() bool {
+	return r.idempotent || r.method == http.MethodGet || r.method == http.MethodPut || r.method == http.MethodDelete
+}
+
+// do sends r, retrying it as long as that is safe, and returns the response
+// if it has a success status. The caller closes the response body.
+func (c *Client) do(ctx context.Context, name string, r *request) (*http.Response, error) {
+	span := tracer.StartSpanFromContext(ctx, name)
+	defer span.Finish()
+
+	if r.idempotent && r.header.Get(idempotencyHeader) == "" {
+		r.header.Set(idempotencyHeader, idempotencyKeyFrom(ctx))
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, span, r)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		if err == nil {
+			err = responseError(resp)
+			if !temporary(resp.StatusCode) {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+		}
+
+		if !r.retryable() || attempt >= c.retries || ctx.Err() != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, span opentracing.Span, r *request) (*http.Response, error) {
+	u := c.baseURL + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+
+	tracer.Inject(span, req)
+
+	return c.http.Do(req)
+}
+
+// temporary reports whether a response status is worth retrying.
+func temporary(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// duplicateCode is the problem code of a write whose idempotency key the
+// server has already seen.
+var duplicateCode = cs.Code(cs.ErrDuplicate)
+
+// responseError reads the error of a response and closes its body. Errors
+// are RFC 7807 problem details; other bodies, as sent by proxies, are kept
+// as the message.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediatype == "application/problem+json" {
+		var problem struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+			Code   string `json:"code"`
+		}
+		if json.Unmarshal(data, &problem) == nil {
+			if problem.Code == duplicateCode {
+				return ErrDuplicate
+			}
+
+			msg := problem.Detail
+			if msg == "" {
+				msg = problem.Title
+			}
+			return &Error{StatusCode: resp.StatusCode, Code: problem.Code, Message: msg}
+		}
+	}
+
+	return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+}
+
+// call sends r and decodes the JSON response into v, unless v is nil.
+func (c *Client) call(ctx context.Context, name string, r *request, v interface{}) (*http.Response, error) {
+	resp, err := c.do(ctx, name, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return resp, nil
+	}
+	return resp, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// create sends a write that answers with 201 Created, the stored resource
+// and the idempotency key the write was recorded under.
+func (c *Client) create(ctx context.Context, name string, r *request) (*Created, error) {
+	return c.createResource(ctx, name, r, nil)
+}
+
+// createResource is create that also decodes the stored resource into v,
+// unless v is nil.
+func (c *Client) createResource(ctx context.Context, name string, r *request, v interface{}) (*Created, error) {
+	r.idempotent = true
+
+	var body struct {
+		Resource       json.RawMessage `json:"resource"`
+		IdempotencyKey string          `json:"idempotencyKey"`
+	}
+	resp, err := c.call(ctx, name, r, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(body.Resource, v); err != nil {
+			return nil, err
+		}
+	}
+
+	created := &Created{Index: etagIndex(resp), IdempotencyKey: body.IdempotencyKey, Location: resp.Header.Get("Location")}
+
+	// Only configs and groups carry an ID and version; what else a write
+	// may store, like the members added to a group, is left to Location.
+	var resource struct {
+		ID      string `json:"id"`
+		Version string `json:"version"`
+	}
+	if json.Unmarshal(body.Resource, &resource) == nil {
+		created.ID, created.Version = resource.ID, resource.Version
+	}
+	return created, nil
+}
+
+// etagIndex returns the store index an ETag header refers to, or 0. Tags
+// of formats other than JSON carry the format after the index.
+func etagIndex(resp *http.Response) uint64 {
+	tag, err := strconv.Unquote(strings.TrimPrefix(resp.Header.Get("ETag"), "W/"))
+	if err != nil {
+		return 0
+	}
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		tag = tag[:i]
+	}
+	index, _ := strconv.ParseUint(tag, 10, 64)
+	return index
+}
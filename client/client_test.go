@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// recorder serves the responses of respond and keeps the requests it was
+// sent.
+type recorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	respond  func(n int, w http.ResponseWriter, r *http.Request)
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	n := len(rec.requests)
+	rec.requests = append(rec.requests, r)
+	rec.mu.Unlock()
+
+	rec.respond(n, w, r)
+}
+
+func newTestClient(t *testing.T, respond func(n int, w http.ResponseWriter, r *http.Request)) (*Client, *recorder) {
+	t.Helper()
+
+	rec := &recorder{respond: respond}
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL, WithBackoff(time.Millisecond)), rec
+}
+
+func problem(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"title": http.StatusText(status), "code": code})
+}
+
+func TestWriteIsRetriedUnderOneIdempotencyKey(t *testing.T) {
+	c, rec := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		if n < 2 {
+			problem(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		w.Header().Set("ETag", `"7"`)
+		w.Header().Set("Location", "/config/a/v1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"resource": {"id": "a", "version": "v1"}, "idempotencyKey": %q}`, r.Header.Get(idempotencyHeader))
+	})
+
+	created, err := c.CreateConfig(context.Background(), &cs.Config{Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.requests) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(rec.requests))
+	}
+	key := rec.requests[0].Header.Get(idempotencyHeader)
+	if key == "" {
+		t.Fatal("write sent without an idempotency key")
+	}
+	for i, r := range rec.requests {
+		if got := r.Header.Get(idempotencyHeader); got != key {
+			t.Errorf("attempt %d sent key %q, want %q", i, got, key)
+		}
+	}
+
+	want := Created{ID: "a", Version: "v1", Index: 7, IdempotencyKey: key, Location: "/config/a/v1"}
+	if *created != want {
+		t.Errorf("created %+v, want %+v", *created, want)
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	c, rec := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"resource": {}}`)
+	})
+
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+	for i := 0; i < 2; i++ {
+		if _, err := c.CreateConfig(ctx, &cs.Config{Version: "v1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, r := range rec.requests {
+		if got := r.Header.Get(idempotencyHeader); got != "key-1" {
+			t.Errorf("write %d sent key %q, want key-1", i, got)
+		}
+	}
+}
+
+func TestOnlyTemporaryFailuresAreRetried(t *testing.T) {
+	tests := []struct {
+		status int
+		sent   int
+	}{
+		{http.StatusServiceUnavailable, 1 + defaultRetries},
+		{http.StatusTooManyRequests, 1 + defaultRetries},
+		{http.StatusNotFound, 1},
+		{http.StatusUnprocessableEntity, 1},
+	}
+
+	for _, test := range tests {
+		c, rec := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		})
+
+		if _, err := c.GetConfig(context.Background(), "a", "v1"); err == nil {
+			t.Errorf("%d: read succeeded", test.status)
+		}
+		if len(rec.requests) != test.sent {
+			t.Errorf("%d: sent %d requests, want %d", test.status, len(rec.requests), test.sent)
+		}
+	}
+}
+
+func TestErrorsMatchStoreErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+		want   error
+	}{
+		{http.StatusNotFound, "not_found", cs.ErrNotFound},
+		{http.StatusConflict, "already_exists", cs.ErrExists},
+		{http.StatusConflict, "immutable", cs.ErrImmutable},
+		{http.StatusConflict, "aborted", cs.ErrAborted},
+		{http.StatusUnprocessableEntity, "validation_failed", cs.ErrValidation},
+		{http.StatusPreconditionFailed, "precondition_failed", cs.ErrPreconditionFailed},
+		{http.StatusConflict, "duplicate_request", ErrDuplicate},
+		// Without a code, as from a proxy, the status tells.
+		{http.StatusNotFound, "", cs.ErrNotFound},
+		{http.StatusPreconditionFailed, "", cs.ErrPreconditionFailed},
+		{http.StatusServiceUnavailable, "", cs.ErrUnavailable},
+	}
+
+	for _, test := range tests {
+		c, _ := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+			if test.code == "" {
+				http.Error(w, "upstream says no", test.status)
+				return
+			}
+			problem(w, test.status, test.code)
+		})
+		c.retries = 0
+
+		_, err := c.GetConfig(context.Background(), "a", "v1")
+		if !errors.Is(err, test.want) {
+			t.Errorf("%d %q: got %v, want %v", test.status, test.code, err, test.want)
+		}
+
+		var apiErr *Error
+		if test.want != ErrDuplicate && (!errors.As(err, &apiErr) || apiErr.StatusCode != test.status) {
+			t.Errorf("%d %q: got %#v, want an *Error with the status", test.status, test.code, err)
+		}
+	}
+}
+
+func TestEtagIndex(t *testing.T) {
+	tests := []struct {
+		etag string
+		want uint64
+	}{
+		{`"42"`, 42},
+		{`W/"42"`, 42},
+		{`"42-yaml"`, 42},
+		{`"42-json+nested"`, 42},
+		{"", 0},
+		{`"abc"`, 0},
+		{"42", 0},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{"Etag": {test.etag}}}
+		if got := etagIndex(resp); got != test.want {
+			t.Errorf("etagIndex(%s) = %d, want %d", test.etag, got, test.want)
+		}
+	}
+}
+
+func TestQueryConfigsSendsLabels(t *testing.T) {
+	c, rec := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	ctx := context.Background()
+	if _, err := c.QueryConfigs(ctx, "g", "v1", map[string]string{"env": "dev", "region": "eu"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.QueryConfigs(ctx, "g", "v1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rec.requests[0].URL.Query(); len(got) != 2 || got.Get("env") != "dev" || got.Get("region") != "eu" {
+		t.Errorf("query for labels sent %v", got)
+	}
+	// The server answers the unlabelled members to a query without labels.
+	if got := rec.requests[1].URL.RawQuery; got != "" {
+		t.Errorf("query without labels sent %q", got)
+	}
+}
+
+func TestWatchConfigFollowsChangesUntilDeleted(t *testing.T) {
+	c, rec := newTestClient(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			w.Header().Set("ETag", `"3"`)
+			w.Header().Set("X-Store-Index", "3")
+			fmt.Fprint(w, `{"id": "a", "version": "v1", "entries": {"k": "1"}}`)
+		case 1:
+			// The wait ended with a change elsewhere in the store.
+			w.Header().Set("X-Store-Index", "4")
+			w.WriteHeader(http.StatusNotModified)
+		case 2:
+			w.Header().Set("ETag", `"5"`)
+			w.Header().Set("X-Store-Index", "5")
+			fmt.Fprint(w, `{"id": "a", "version": "v1", "entries": {"k": "2"}}`)
+		default:
+			problem(w, http.StatusNotFound, "not_found")
+		}
+	})
+
+	var seen []string
+	err := c.WatchConfig(context.Background(), "a", "v1", func(config *cs.Config) error {
+		seen = append(seen, config.Entries["k"]+"@"+strconv.FormatUint(config.Index, 10))
+		return nil
+	})
+	if !errors.Is(err, cs.ErrNotFound) {
+		t.Errorf("watch ended with %v, want ErrNotFound", err)
+	}
+
+	if len(seen) != 2 || seen[0] != "1@3" || seen[1] != "2@5" {
+		t.Errorf("watch passed on %v, want [1@3 2@5]", seen)
+	}
+
+	wants := []struct{ index, match string }{{"", ""}, {"3", `"3"`}, {"4", `"3"`}, {"5", `"5"`}}
+	for i, want := range wants {
+		r := rec.requests[i]
+		if got := r.URL.Query().Get("index"); got != want.index {
+			t.Errorf("read %d waited on index %q, want %q", i, got, want.index)
+		}
+		if got := r.Header.Get("If-None-Match"); got != want.match {
+			t.Errorf("read %d sent If-None-Match %q, want %q", i, got, want.match)
+		}
+	}
+}
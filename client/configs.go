@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// CreateConfig creates a config under a new ID from the version, entries and
+// state of config.
+func (c *Client) CreateConfig(ctx context.Context, config *cs.Config) (*Created, error) {
+	r, err := newRequest(http.MethodPost, "/config/").withJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	return c.create(ctx, "CreateConfig", r)
+}
+
+// CreateConfigVersion adds config as a new version of the config id.
+func (c *Client) CreateConfigVersion(ctx context.Context, id string, config *cs.Config) (*Created, error) {
+	r, err := newRequest(http.MethodPost, "/config/%s", id).withJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	return c.create(ctx, "CreateConfigVersion", r)
+}
+
+// GetConfig returns a config version, with Index set for conditional
+// writes.
+func (c *Client) GetConfig(ctx context.Context, id, ver string) (*cs.Config, error) {
+	config := &cs.Config{}
+	resp, err := c.call(ctx, "GetConfig", newRequest(http.MethodGet, "/config/%s/%s", id, ver), config)
+	if err != nil {
+		return nil, err
+	}
+	config.Index = etagIndex(resp)
+	return config, nil
+}
+
+func (c *Client) ListConfigVersions(ctx context.Context, id string) ([]*cs.Config, error) {
+	var configs []*cs.Config
+	_, err := c.call(ctx, "ListConfigVersions", newRequest(http.MethodGet, "/config/%s/", id), &configs)
+	return configs, err
+}
+
+// UpdateConfig replaces the entries of a draft version. A non-zero
+// config.Index must still be the index of the version.
+func (c *Client) UpdateConfig(ctx context.Context, config *cs.Config) (*cs.Config, error) {
+	r, err := newRequest(http.MethodPut, "/config/%s/%s", config.ID, config.Version).withJSON(&cs.Config{Entries: config.Entries})
+	if err != nil {
+		return nil, err
+	}
+
+	updated := &cs.Config{}
+	resp, err := c.call(ctx, "UpdateConfig", r.withMatch(config.Index), updated)
+	if err != nil {
+		return nil, err
+	}
+	updated.Index = etagIndex(resp)
+	return updated, nil
+}
+
+// PatchConfig applies patch to the entries of a config version and stores
+// the result as newVer. The media type is either
+// "application/merge-patch+json" or "application/json-patch+json".
+func (c *Client) PatchConfig(ctx context.Context, id, ver, newVer string, patch []byte, mediaType string) (*Created, error) {
+	r := newRequest(http.MethodPatch, "/config/%s/%s", id, ver)
+	r.header.Set("X-Config-Version", newVer)
+	r.body = patch
+	r.contentType = mediaType
+	return c.create(ctx, "PatchConfig", r)
+}
+
+// SetConfigState moves a config version to state. A non-zero match must
+// still be the index of the version.
+func (c *Client) SetConfigState(ctx context.Context, id, ver, state string, match uint64) (*cs.Config, error) {
+	r, err := newRequest(http.MethodPut, "/config/%s/%s/state", id, ver).withJSON(map[string]string{"state": state})
+	if err != nil {
+		return nil, err
+	}
+
+	config := &cs.Config{}
+	resp, err := c.call(ctx, "SetConfigState", r.withMatch(match), config)
+	if err != nil {
+		return nil, err
+	}
+	config.Index = etagIndex(resp)
+	return config, nil
+}
+
+// DeleteConfig moves a config version to the trash. A non-zero match must
+// still be the index of the version.
+func (c *Client) DeleteConfig(ctx context.Context, id, ver string, match uint64) error {
+	_, err := c.call(ctx, "DeleteConfig", newRequest(http.MethodDelete, "/config/%s/%s", id, ver).withMatch(match), nil)
+	return err
+}
+
+// DeleteConfigVersions moves every version of a config to the trash. Force
+// is needed when some of them are published.
+func (c *Client) DeleteConfigVersions(ctx context.Context, id string, force bool) error {
+	r := newRequest(http.MethodDelete, "/config/%s/", id)
+	if force {
+		r.query = url.Values{"force": {"true"}}
+	}
+	_, err := c.call(ctx, "DeleteConfigVersions", r, nil)
+	return err
+}
+
+// RestoreConfig takes a deleted config version back out of the trash.
+func (c *Client) RestoreConfig(ctx context.Context, id, ver string) (*cs.Config, error) {
+	config := &cs.Config{}
+	resp, err := c.call(ctx, "RestoreConfig", newRequest(http.MethodPost, "/config/%s/%s/restore", id, ver), config)
+	if err != nil {
+		return nil, err
+	}
+	config.Index = etagIndex(resp)
+	return config, nil
+}
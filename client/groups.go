@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// CreateGroup creates a group under a new ID from the version, members and
+// state of group.
+func (c *Client) CreateGroup(ctx context.Context, group *cs.Group) (*Created, error) {
+	r, err := newRequest(http.MethodPost, "/group/").withJSON(group)
+	if err != nil {
+		return nil, err
+	}
+	return c.create(ctx, "CreateGroup", r)
+}
+
+// CreateGroupVersion adds group as a new version of the group id.
+func (c *Client) CreateGroupVersion(ctx context.Context, id string, group *cs.Group) (*Created, error) {
+	r, err := newRequest(http.MethodPost, "/group/%s", id).withJSON(group)
+	if err != nil {
+		return nil, err
+	}
+	return c.create(ctx, "CreateGroupVersion", r)
+}
+
+// GetGroup returns a group version, with Index set for conditional writes.
+func (c *Client) GetGroup(ctx context.Context, id, ver string) (*cs.Group, error) {
+	group := &cs.Group{}
+	resp, err := c.call(ctx, "GetGroup", newRequest(http.MethodGet, "/group/%s/%s/", id, ver), group)
+	if err != nil {
+		return nil, err
+	}
+	group.Index = etagIndex(resp)
+	return group, nil
+}
+
+// SetGroupState moves a group version to state. A non-zero match must still
+// be the index of the version.
+func (c *Client) SetGroupState(ctx context.Context, id, ver, state string, match uint64) (*cs.Group, error) {
+	r, err := newRequest(http.MethodPut, "/group/%s/%s/state", id, ver).withJSON(map[string]string{"state": state})
+	if err != nil {
+		return nil, err
+	}
+
+	group := &cs.Group{}
+	resp, err := c.call(ctx, "SetGroupState", r.withMatch(match), group)
+	if err != nil {
+		return nil, err
+	}
+	group.Index = etagIndex(resp)
+	return group, nil
+}
+
+// DeleteGroup moves a group version to the trash. A non-zero match must
+// still be the index of the version.
+func (c *Client) DeleteGroup(ctx context.Context, id, ver string, match uint64) error {
+	_, err := c.call(ctx, "DeleteGroup", newRequest(http.MethodDelete, "/group/%s/%s/", id, ver).withMatch(match), nil)
+	return err
+}
+
+// DeleteGroupVersions moves every version of a group to the trash. Force is
+// needed when some of them are published.
+func (c *Client) DeleteGroupVersions(ctx context.Context, id string, force bool) error {
+	r := newRequest(http.MethodDelete, "/group/%s/", id)
+	if force {
+		r.query = url.Values{"force": {"true"}}
+	}
+	_, err := c.call(ctx, "DeleteGroupVersions", r, nil)
+	return err
+}
+
+// RestoreGroup takes a deleted group version back out of the trash.
+func (c *Client) RestoreGroup(ctx context.Context, id, ver string) (*cs.Group, error) {
+	group := &cs.Group{}
+	resp, err := c.call(ctx, "RestoreGroup", newRequest(http.MethodPost, "/group/%s/%s/restore", id, ver), group)
+	if err != nil {
+		return nil, err
+	}
+	group.Index = etagIndex(resp)
+	return group, nil
+}
+
+// QueryConfigs returns the members of a group version labelled with exactly
+// labels, no more and no fewer. No labels return the unlabelled members.
+func (c *Client) QueryConfigs(ctx context.Context, id, ver string, labels map[string]string) ([]*cs.GroupConfig, error) {
+	r := newRequest(http.MethodGet, "/group/%s/%s/config/", id, ver)
+	r.query = labelValues(labels)
+
+	var configs []*cs.GroupConfig
+	_, err := c.call(ctx, "QueryConfigs", r, &configs)
+	return configs, err
+}
+
+// AddConfigs adds members to a draft group version. A non-zero match must
+// still be the index of the version. Only the index, idempotency key and
+// location of the result are set.
+func (c *Client) AddConfigs(ctx context.Context, id, ver string, configs []*cs.GroupConfig, match uint64) (*Created, error) {
+	r, err := newRequest(http.MethodPost, "/group/%s/%s/config/", id, ver).withJSON(configs)
+	if err != nil {
+		return nil, err
+	}
+	return c.create(ctx, "AddConfigs", r.withMatch(match))
+}
+
+// RemoveConfigs removes the members labelled with exactly labels from a
+// draft group version and returns them. A non-zero match must still be the
+// index of the version.
+func (c *Client) RemoveConfigs(ctx context.Context, id, ver string, labels map[string]string, match uint64) ([]*cs.GroupConfig, error) {
+	r := newRequest(http.MethodDelete, "/group/%s/%s/config/", id, ver).withMatch(match)
+	r.query = labelValues(labels)
+
+	var configs []*cs.GroupConfig
+	_, err := c.call(ctx, "RemoveConfigs", r, &configs)
+	return configs, err
+}
+
+func labelValues(labels map[string]string) url.Values {
+	values := make(url.Values)
+	for k, v := range labels {
+		values.Set(k, v)
+	}
+	return values
+}
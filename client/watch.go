@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// watchWait is how long a single blocking read waits for a change.
+const watchWait = 5 * time.Minute
+
+// WatchConfig calls fn with a config version and again every time it
+// changes, until fn returns an error, the version is deleted or ctx is done.
+func (c *Client) WatchConfig(ctx context.Context, id, ver string, fn func(*cs.Config) error) error {
+	return c.watch(ctx, "WatchConfig", newRequest(http.MethodGet, "/config/%s/%s", id, ver), func(resp *http.Response) (uint64, error) {
+		config := &cs.Config{}
+		if err := json.NewDecoder(resp.Body).Decode(config); err != nil {
+			return 0, err
+		}
+		config.Index = etagIndex(resp)
+		return config.Index, fn(config)
+	})
+}
+
+// WatchGroup calls fn with a group version and again every time it
+// changes, until fn returns an error, the version is deleted or ctx is done.
+func (c *Client) WatchGroup(ctx context.Context, id, ver string, fn func(*cs.Group) error) error {
+	return c.watch(ctx, "WatchGroup", newRequest(http.MethodGet, "/group/%s/%s/", id, ver), func(resp *http.Response) (uint64, error) {
+		group := &cs.Group{}
+		if err := json.NewDecoder(resp.Body).Decode(group); err != nil {
+			return 0, err
+		}
+		group.Index = etagIndex(resp)
+		return group.Index, fn(group)
+	})
+}
+
+// watch repeats r as a blocking read, passing the response on to handle
+// whenever the version it returns differs from the one handled last.
+func (c *Client) watch(ctx context.Context, name string, r *request, handle func(*http.Response) (uint64, error)) error {
+	var index, seen uint64
+	for {
+		r.query = url.Values{"wait": {watchWait.String()}}
+		if index != 0 {
+			r.query.Set("index", strconv.FormatUint(index, 10))
+		}
+		r.header.Del("If-None-Match")
+		if seen != 0 {
+			// An unchanged version comes back as 304 without a body.
+			r.header.Set("If-None-Match", strconv.Quote(strconv.FormatUint(seen, 10)))
+		}
+
+		resp, err := c.do(ctx, name, r)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusNotModified {
+			seen, err = handle(resp)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		next, _ := strconv.ParseUint(resp.Header.Get("X-Store-Index"), 10, 64)
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// EventFilter selects the events passed on by Events. Empty fields match
+// everything; Selector is a label selector such as "env=prod,team=core".
+type EventFilter struct {
+	Config   string
+	Group    string
+	Selector string
+}
+
+// Events streams the changes matching filter to fn until fn returns an
+// error or ctx is done. Events after lastSeq are replayed first, as far as
+// the server still has them; pass 0 to only receive new events. When some
+// of them are gone, fn first receives an event whose Operation is
+// cs.EventReset, after which the state should be read afresh.
+func (c *Client) Events(ctx context.Context, filter EventFilter, lastSeq uint64, fn func(*cs.Event) error) error {
+	r := newRequest(http.MethodGet, "/events")
+	r.query = make(url.Values)
+	for k, v := range map[string]string{"config": filter.Config, "group": filter.Group, "selector": filter.Selector} {
+		if v != "" {
+			r.query.Set(k, v)
+		}
+	}
+	if lastSeq != 0 {
+		r.header.Set("Last-Event-ID", strconv.FormatUint(lastSeq, 10))
+	}
+
+	resp, err := c.do(ctx, "Events", r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		event := &cs.Event{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// CreateWebhook registers a webhook for the URL, config or group and secret
+// of hook. Without a secret the server generates one; the returned webhook
+// is the only place it is shown. The write is retried under the same
+// idempotency key, so it registers a single webhook.
+func (c *Client) CreateWebhook(ctx context.Context, hook *cs.Webhook) (*cs.Webhook, error) {
+	r, err := newRequest(http.MethodPost, "/webhook/").withJSON(map[string]string{
+		"url":    hook.URL,
+		"secret": hook.Secret,
+		"config": hook.Config,
+		"group":  hook.Group,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created := &cs.Webhook{}
+	_, err = c.createResource(ctx, "CreateWebhook", r, created)
+	return created, err
+}
+
+func (c *Client) ListWebhooks(ctx context.Context) ([]*cs.Webhook, error) {
+	var hooks []*cs.Webhook
+	_, err := c.call(ctx, "ListWebhooks", newRequest(http.MethodGet, "/webhook/"), &hooks)
+	return hooks, err
+}
+
+func (c *Client) GetWebhook(ctx context.Context, id string) (*cs.Webhook, error) {
+	hook := &cs.Webhook{}
+	_, err := c.call(ctx, "GetWebhook", newRequest(http.MethodGet, "/webhook/%s", id), hook)
+	return hook, err
+}
+
+// DeleteWebhook removes a webhook along with its deliveries and dead
+// letters.
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := c.call(ctx, "DeleteWebhook", newRequest(http.MethodDelete, "/webhook/%s", id), nil)
+	return err
+}
+
+// Deliveries returns the latest deliveries of a webhook, newest first.
+func (c *Client) Deliveries(ctx context.Context, id string) ([]*cs.Delivery, error) {
+	var deliveries []*cs.Delivery
+	_, err := c.call(ctx, "Deliveries", newRequest(http.MethodGet, "/webhook/%s/deliveries", id), &deliveries)
+	return deliveries, err
+}
+
+// DeadLetters returns the deliveries of a webhook that ran out of retries.
+func (c *Client) DeadLetters(ctx context.Context, id string) ([]*cs.Delivery, error) {
+	var deliveries []*cs.Delivery
+	_, err := c.call(ctx, "DeadLetters", newRequest(http.MethodGet, "/webhook/%s/dead-letters", id), &deliveries)
+	return deliveries, err
+}
+
+// Redeliver takes a dead letter off the list and sends its event again as
+// a new delivery, which is returned.
+func (c *Client) Redeliver(ctx context.Context, id, delivery string) (*cs.Delivery, error) {
+	redelivery := &cs.Delivery{}
+	_, err := c.call(ctx, "Redeliver", newRequest(http.MethodPost, "/webhook/%s/dead-letters/%s/redeliver", id, delivery), redelivery)
+	return redelivery, err
+}
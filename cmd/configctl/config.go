@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dekeract10/ARS-projekat/client"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// bodyFlags adds the flags of commands that read a body.
+func bodyFlags(flags *flag.FlagSet) (file, name *string) {
+	file = flags.String("f", "-", "file to read the body from, - for stdin")
+	name = flags.String("format", "", "format of the body, json, yaml or toml; taken from the file extension by default")
+	return file, name
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.Usage = func() {}
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
+
+func (c *cli) config(ctx context.Context, cmd string, args []string) error {
+	switch cmd {
+	case "create":
+		return c.configCreate(ctx, args)
+	case "get":
+		return c.configGet(ctx, args)
+	case "versions":
+		return c.configVersions(ctx, args)
+	case "delete":
+		return c.configDelete(ctx, args)
+	case "diff":
+		return c.configDiff(ctx, args)
+	}
+	return errUsage
+}
+
+func (c *cli) configCreate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("config create", flag.ContinueOnError)
+	id := flags.String("id", "", "config to create a version of")
+	file, name := bodyFlags(flags)
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) != 0 {
+		return errUsage
+	}
+
+	config := &cs.Config{}
+	if err := readBody(*file, *name, config); err != nil {
+		return err
+	}
+
+	var created *client.Created
+	if *id == "" {
+		created, err = c.client.CreateConfig(ctx, config)
+	} else {
+		created, err = c.client.CreateConfigVersion(ctx, *id, config)
+	}
+	if err != nil {
+		return err
+	}
+	return c.printCreated(created)
+}
+
+func (c *cli) configGet(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	config, err := c.client.GetConfig(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return printJSON(config)
+	}
+	fmt.Printf("%s %s (%s)\n\n", config.ID, config.Version, config.State)
+	return printTable([]string{"KEY", "VALUE"}, entryRows(config.Entries))
+}
+
+func (c *cli) configVersions(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	configs, err := c.client.ListConfigVersions(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(configs))
+	for i, config := range configs {
+		rows[i] = []string{config.Version, config.State, strconv.Itoa(len(config.Entries))}
+	}
+	return c.print(configs, []string{"VERSION", "STATE", "ENTRIES"}, rows)
+}
+
+func (c *cli) configDelete(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("config delete", flag.ContinueOnError)
+	force := flags.Bool("force", false, "delete every version even if some are published")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	switch len(args) {
+	case 1:
+		return c.client.DeleteConfigVersions(ctx, args[0], *force)
+	case 2:
+		return c.client.DeleteConfig(ctx, args[0], args[1], 0)
+	}
+	return errUsage
+}
+
+// entryChange is a difference between the entries of two versions.
+type entryChange struct {
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+func (c *cli) configDiff(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	from, err := c.client.GetConfig(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	to, err := c.client.GetConfig(ctx, args[0], args[2])
+	if err != nil {
+		return err
+	}
+
+	changes := diffEntries(from.Entries, to.Entries)
+	rows := make([][]string, len(changes))
+	for i, ch := range changes {
+		rows[i] = []string{ch.Change, ch.Key, ch.Old, ch.New}
+	}
+	return c.print(changes, []string{"CHANGE", "KEY", args[1], args[2]}, rows)
+}
+
+// diffEntries lists the keys added, removed or changed from one set of
+// entries to another, ordered by key.
+func diffEntries(from, to cs.Entries) []entryChange {
+	changes := []entryChange{}
+	for k, old := range from {
+		v, ok := to[k]
+		switch {
+		case !ok:
+			changes = append(changes, entryChange{Key: k, Change: "removed", Old: old})
+		case v != old:
+			changes = append(changes, entryChange{Key: k, Change: "changed", Old: old, New: v})
+		}
+	}
+	for k, v := range to {
+		if _, ok := from[k]; !ok {
+			changes = append(changes, entryChange{Key: k, Change: "added", New: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+func entryRows(entries cs.Entries) [][]string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rows := make([][]string, len(keys))
+	for i, k := range keys {
+		rows[i] = []string{k, entries[k]}
+	}
+	return rows
+}
+
+func (c *cli) printCreated(created *client.Created) error {
+	out := struct {
+		ID             string `json:"id,omitempty"`
+		Version        string `json:"version,omitempty"`
+		Index          uint64 `json:"index,omitempty"`
+		IdempotencyKey string `json:"idempotencyKey"`
+		Location       string `json:"location"`
+	}{created.ID, created.Version, created.Index, created.IdempotencyKey, created.Location}
+
+	return c.print(out, []string{"LOCATION", "IDEMPOTENCY KEY"}, [][]string{{out.Location, out.IdempotencyKey}})
+}
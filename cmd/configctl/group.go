@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/dekeract10/ARS-projekat/client"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/format"
+)
+
+func (c *cli) group(ctx context.Context, cmd string, args []string) error {
+	switch cmd {
+	case "create":
+		return c.groupCreate(ctx, args)
+	case "get":
+		return c.groupGet(ctx, args)
+	case "add":
+		return c.groupAdd(ctx, args)
+	case "query":
+		return c.groupQuery(ctx, args)
+	}
+	return errUsage
+}
+
+func (c *cli) groupCreate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("group create", flag.ContinueOnError)
+	id := flags.String("id", "", "group to create a version of")
+	file, name := bodyFlags(flags)
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) != 0 {
+		return errUsage
+	}
+
+	group := &cs.Group{}
+	if err := readBody(*file, *name, group); err != nil {
+		return err
+	}
+
+	var created *client.Created
+	if *id == "" {
+		created, err = c.client.CreateGroup(ctx, group)
+	} else {
+		created, err = c.client.CreateGroupVersion(ctx, *id, group)
+	}
+	if err != nil {
+		return err
+	}
+	return c.printCreated(created)
+}
+
+func (c *cli) groupGet(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	group, err := c.client.GetGroup(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return printJSON(group)
+	}
+	fmt.Printf("%s %s (%s)\n\n", group.ID, group.Version, group.State)
+	return printTable([]string{"LABELS", "ENTRIES"}, memberRows(group.Configs))
+}
+
+func (c *cli) groupAdd(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("group add", flag.ContinueOnError)
+	file, name := bodyFlags(flags)
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) != 2 {
+		return errUsage
+	}
+
+	f, err := bodyFormat(*file, *name)
+	if err != nil {
+		return err
+	}
+
+	// A TOML document can't be a list, so members are given as
+	// [[configs]] tables, as the server takes them.
+	var configs []*cs.GroupConfig
+	if f == format.TOML {
+		var body struct {
+			Configs []*cs.GroupConfig `json:"configs"`
+		}
+		err = decodeBody(*file, f, &body)
+		configs = body.Configs
+	} else {
+		err = decodeBody(*file, f, &configs)
+	}
+	if err != nil {
+		return err
+	}
+
+	created, err := c.client.AddConfigs(ctx, args[0], args[1], configs, 0)
+	if err != nil {
+		return err
+	}
+	return c.printCreated(created)
+}
+
+func (c *cli) groupQuery(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	labels, err := parsePairs(args[2:])
+	if err != nil {
+		return err
+	}
+
+	configs, err := c.client.QueryConfigs(ctx, args[0], args[1], labels)
+	if err != nil {
+		return err
+	}
+	return c.print(configs, []string{"LABELS", "ENTRIES"}, memberRows(configs))
+}
+
+func memberRows(configs []*cs.GroupConfig) [][]string {
+	rows := make([][]string, len(configs))
+	for i, config := range configs {
+		rows[i] = []string{pairs(config.Labels), strconv.Itoa(len(config.Entries))}
+	}
+	return rows
+}
@@ -0,0 +1,103 @@
+// Command configctl manages configs and groups of a config store from the
+// command line.
+//
+//	configctl [-profile name] [-server url] [-o table|json] <command> ...
+//
+// Bodies are read from the file given with -f, or from stdin, in JSON, YAML
+// or TOML. Servers can be saved as profiles, see "configctl profile".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dekeract10/ARS-projekat/client"
+)
+
+const usage = `usage: configctl [-profile name] [-server url] [-o table|json] <command>
+
+commands:
+  config create [-id id] [-f file]      create a config, or a version of config id
+  config get <id> <ver>                 show a config version
+  config versions <id>                  list the versions of a config
+  config delete [-force] <id> [<ver>]   delete a version, or every version
+  config diff <id> <ver> <ver>          compare the entries of two versions
+  group create [-id id] [-f file]       create a group, or a version of group id
+  group get <id> <ver>                  show a group version
+  group add [-f file] <id> <ver>        add members to a draft group version
+  group query <id> <ver> [k=v ...]      list the members with exactly these labels
+  profile list                          list the saved profiles
+  profile set <name> <server>           save a profile
+  profile use <name>                    make a profile the default
+
+Bodies are read from stdin unless -f is given, and -format overrides the
+format taken from the file extension. Members added with group add are a
+list, or [[configs]] tables in TOML.
+`
+
+var errUsage = errors.New(usage)
+
+// cli holds what every command needs.
+type cli struct {
+	client *client.Client
+	output string
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("configctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	profile := flags.String("profile", "", "profile to use instead of the default one")
+	server := flags.String("server", "", "URL of the config store, overriding the profile")
+	output := flags.String("o", "table", "output format, table or json")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	args = flags.Args()
+	if len(args) < 2 {
+		return errUsage
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "profile" {
+		return profiles.command(args[1:], *output)
+	}
+
+	url := *server
+	if url == "" {
+		url, err = profiles.server(*profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := &cli{client: client.New(url), output: *output}
+	ctx := context.Background()
+	switch args[0] {
+	case "config":
+		return c.config(ctx, args[1], args[2:])
+	case "group":
+		return c.group(ctx, args[1], args[2:])
+	}
+	return errUsage
+}
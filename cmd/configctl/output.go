@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dekeract10/ARS-projekat/format"
+)
+
+// extFormats picks the format of a body file from its extension.
+var extFormats = map[string]string{
+	".json": format.JSON,
+	".yaml": format.YAML,
+	".yml":  format.YAML,
+	".toml": format.TOML,
+}
+
+// readBody decodes v from file, or from stdin when file is "-", in the
+// format bodyFormat picks.
+func readBody(file, name string, v interface{}) error {
+	f, err := bodyFormat(file, name)
+	if err != nil {
+		return err
+	}
+	return decodeBody(file, f, v)
+}
+
+// bodyFormat returns the format of a body: name if set, otherwise the one
+// the extension of file stands for. Stdin is read as YAML by default, which
+// accepts JSON as well.
+func bodyFormat(file, name string) (string, error) {
+	switch {
+	case name != "":
+		f, err := format.FromName(name)
+		if err != nil {
+			return "", fmt.Errorf("unknown format %q", name)
+		}
+		return f, nil
+	case file != "-":
+		f, ok := extFormats[strings.ToLower(filepath.Ext(file))]
+		if !ok {
+			return "", fmt.Errorf("can't tell the format of %q from its extension, pass -format", file)
+		}
+		return f, nil
+	}
+	return format.YAML, nil
+}
+
+// decodeBody decodes v in format f from file, or from stdin when file is
+// "-".
+func decodeBody(file, f string, v interface{}) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		r = fd
+	}
+
+	if err := format.Decode(r, f, v); err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// print writes v as JSON, or as the table of header and rows.
+func (c *cli) print(v interface{}, header []string, rows [][]string) error {
+	if c.output == "json" {
+		return printJSON(v)
+	}
+	return printTable(header, rows)
+}
+
+// pairs joins a map into sorted k=v pairs, as labels are written in queries.
+func pairs(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parsePairs reads k=v arguments into a map.
+func parsePairs(args []string) (map[string]string, error) {
+	m := make(map[string]string, len(args))
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", arg)
+		}
+		m[arg[:i]] = arg[i+1:]
+	}
+	return m, nil
+}
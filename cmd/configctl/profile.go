@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultServer = "http://localhost:8000"
+
+// profiles are the servers configctl knows by name, kept in
+// ~/.configctl.yaml unless CONFIGCTL_CONFIG names another file.
+type profiles struct {
+	path string
+
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+type profile struct {
+	Server string `yaml:"server"`
+}
+
+func profilesPath() (string, error) {
+	if path := os.Getenv("CONFIGCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".configctl.yaml"), nil
+}
+
+func loadProfiles() (*profiles, error) {
+	path, err := profilesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &profiles{path: path, Profiles: make(map[string]profile)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if p.Profiles == nil {
+		p.Profiles = make(map[string]profile)
+	}
+	return p, nil
+}
+
+func (p *profiles) save() error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0600)
+}
+
+// server returns the server of the named profile, or of the default one
+// when name is empty. Without any profile the local server is used.
+func (p *profiles) server(name string) (string, error) {
+	if name == "" {
+		name = p.Current
+	}
+	if name == "" {
+		return defaultServer, nil
+	}
+
+	prof, ok := p.Profiles[name]
+	if !ok {
+		return "", fmt.Errorf("unknown profile %q", name)
+	}
+	return prof.Server, nil
+}
+
+func (p *profiles) command(args []string, output string) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		names := make([]string, 0, len(p.Profiles))
+		for name := range p.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if output == "json" {
+			return printJSON(p)
+		}
+
+		rows := [][]string{}
+		for _, name := range names {
+			current := ""
+			if name == p.Current {
+				current = "*"
+			}
+			rows = append(rows, []string{current, name, p.Profiles[name].Server})
+		}
+		return printTable([]string{"", "NAME", "SERVER"}, rows)
+	case len(args) == 3 && args[0] == "set":
+		p.Profiles[args[1]] = profile{Server: args[2]}
+		if p.Current == "" {
+			p.Current = args[1]
+		}
+		return p.save()
+	case len(args) == 2 && args[0] == "use":
+		if _, ok := p.Profiles[args[1]]; !ok {
+			return fmt.Errorf("unknown profile %q", args[1])
+		}
+		p.Current = args[1]
+		return p.save()
+	}
+	return errUsage
+}
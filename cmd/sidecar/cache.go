@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// cachedItem is the last version received of a watched config or group.
+// Stale is set while the version can't be confirmed with the store.
+type cachedItem struct {
+	Kind      string     `json:"kind"`
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Index     uint64     `json:"index"`
+	Config    *cs.Config `json:"config,omitempty"`
+	Group     *cs.Group  `json:"group,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Stale     bool       `json:"stale"`
+}
+
+func (c *cachedItem) key() string {
+	return itemKey(c.Kind, c.ID, c.Version)
+}
+
+// cache keeps the watched versions in memory and, one JSON file per
+// version, in dir.
+type cache struct {
+	dir string
+
+	mu    sync.RWMutex
+	items map[string]*cachedItem
+}
+
+// openCache loads the versions cached in dir by an earlier run. They are
+// stale until the store confirms them.
+func openCache(dir string) (*cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &cache{dir: dir, items: make(map[string]*cachedItem)}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		cached := &cachedItem{}
+		if err := json.Unmarshal(data, cached); err != nil {
+			return fmt.Errorf("reading cache file %s: %w", path, err)
+		}
+		cached.Stale = true
+		c.items[cached.key()] = cached
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *cache) get(key string) *cachedItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.items[key]
+}
+
+// list returns every cached version ordered by key.
+func (c *cache) list() []*cachedItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]*cachedItem, 0, len(c.items))
+	for _, cached := range c.items {
+		items = append(items, cached)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key() < items[j].key()
+	})
+	return items
+}
+
+// put stores a version received from the store, on disk first so that
+// what is served never runs ahead of what survives a restart.
+func (c *cache) put(cached *cachedItem) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(c.path(cached), data, 0644); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items[cached.key()] = cached
+	return nil
+}
+
+// setStale marks the cached version under key, if there is one. Cached
+// items are replaced rather than changed, so readers never see a change
+// half made.
+func (c *cache) setStale(key string, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.items[key]
+	if !ok || cached.Stale == stale {
+		return
+	}
+
+	marked := *cached
+	marked.Stale = stale
+	c.items[key] = &marked
+}
+
+func (c *cache) path(cached *cachedItem) string {
+	return filepath.Join(c.dir, cached.Kind, url.PathEscape(cached.ID), url.PathEscape(cached.Version)+".json")
+}
+
+// writeFile replaces the file at path in one step, so that readers see
+// either the old or the new content. The file gets mode perm; temporary
+// files are created readable by their owner only.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func itemKey(kind, id, ver string) string {
+	return strings.Join([]string{kind, id, ver}, "/")
+}
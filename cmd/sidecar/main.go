@@ -0,0 +1,108 @@
+// Command sidecar keeps local copies of config and group versions for an
+// application running next to it. It follows every version it is told to
+// watch, caches it on disk, serves it on localhost under the same paths as
+// the config store and optionally renders its entries to a file. When the
+// config store can't be reached it keeps serving the last version it got,
+// marked stale.
+//
+//	sidecar -server http://configstore:8000 \
+//		-watch config/<id>/v1=/etc/app/app.yaml \
+//		-watch group/<id>/v2
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dekeract10/ARS-projekat/client"
+)
+
+type watchFlags []string
+
+func (w *watchFlags) String() string {
+	return strings.Join(*w, ",")
+}
+
+func (w *watchFlags) Set(value string) error {
+	*w = append(*w, value)
+	return nil
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8000", "URL of the config store")
+	listen := flag.String("listen", "127.0.0.1:8100", "address to serve cached versions on")
+	dir := flag.String("cache", "sidecar-cache", "directory of the on-disk cache")
+	mode := flag.String("mode", "0644", "octal file mode of rendered files")
+	var watches watchFlags
+	flag.Var(&watches, "watch", "config/<id>/<ver> or group/<id>/<ver> to follow, optionally followed by =<file> to render it to; repeatable")
+	flag.Parse()
+
+	if len(watches) == 0 {
+		log.Fatal("nothing to watch, pass at least one -watch")
+	}
+
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || perm > 0777 {
+		log.Fatalf("invalid mode %q, expected octal permissions such as 0644", *mode)
+	}
+
+	items := make([]*item, len(watches))
+	for i, spec := range watches {
+		it, err := parseItem(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		items[i] = it
+	}
+
+	cache, err := openCache(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &sidecar{client: client.New(*server), cache: cache, mode: os.FileMode(perm)}
+
+	// Whatever was cached by an earlier run is served and rendered right
+	// away, so the application has its config even if the store is down.
+	for _, it := range items {
+		if cached := cache.get(it.key()); cached != nil {
+			s.render(it, cached)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, it := range items {
+		go s.follow(ctx, it)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	srv := &http.Server{Addr: *listen, Handler: s.router()}
+	go func() {
+		log.Printf("sidecar serving on %s", *listen)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-quit
+	cancel()
+
+	shutdown, done := context.WithTimeout(context.Background(), 10*time.Second)
+	defer done()
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("sidecar stopped")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dekeract10/ARS-projekat/client"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/format"
+	"github.com/gorilla/mux"
+)
+
+// A watch that fails is retried with exponential backoff, starting at
+// retryBackoff and doubling up to retryMaxBackoff.
+const (
+	retryBackoff    = time.Second
+	retryMaxBackoff = time.Minute
+)
+
+// extFormats picks the format a file is rendered in from its extension.
+var extFormats = map[string]string{
+	".json":       format.JSON,
+	".yaml":       format.YAML,
+	".yml":        format.YAML,
+	".toml":       format.TOML,
+	".env":        format.Dotenv,
+	".properties": format.Properties,
+}
+
+// item is a version to watch, given as config/<id>/<ver> or
+// group/<id>/<ver>, and the file its entries are rendered to, if any.
+type item struct {
+	kind    string
+	id      string
+	version string
+	file    string
+	format  string
+}
+
+func (it *item) key() string {
+	return itemKey(it.kind, it.id, it.version)
+}
+
+func parseItem(spec string) (*item, error) {
+	path, file := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		path, file = spec[:i], spec[i+1:]
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || (parts[0] != cs.ResourceConfig && parts[0] != cs.ResourceGroup) || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid watch %q, expected config/<id>/<ver> or group/<id>/<ver>", spec)
+	}
+
+	it := &item{kind: parts[0], id: parts[1], version: parts[2], file: file}
+	if file != "" {
+		f, ok := extFormats[strings.ToLower(filepath.Ext(file))]
+		if !ok {
+			return nil, fmt.Errorf("can't tell the format of %q from its extension", file)
+		}
+		it.format = f
+	}
+	return it, nil
+}
+
+type sidecar struct {
+	client *client.Client
+	cache  *cache
+	// mode is the file mode rendered files are written with.
+	mode os.FileMode
+}
+
+// follow keeps the cached version of it current until ctx is done. While
+// the store can't be reached, is unavailable or reports the version
+// missing, the cached version stays in place and is marked stale.
+func (s *sidecar) follow(ctx context.Context, it *item) {
+	backoff := retryBackoff
+	for {
+		var err error
+		if it.kind == cs.ResourceConfig {
+			err = s.client.WatchConfig(ctx, it.id, it.version, func(config *cs.Config) error {
+				backoff = retryBackoff
+				return s.update(it, &cachedItem{Index: config.Index, Config: config})
+			})
+		} else {
+			err = s.client.WatchGroup(ctx, it.id, it.version, func(group *cs.Group) error {
+				backoff = retryBackoff
+				return s.update(it, &cachedItem{Index: group.Index, Group: group})
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.cache.setStale(it.key(), true)
+		log.Printf("watching %s: %v, retrying in %s", it.key(), err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+}
+
+// update caches a version received from the store and renders it.
+func (s *sidecar) update(it *item, cached *cachedItem) error {
+	cached.Kind, cached.ID, cached.Version = it.kind, it.id, it.version
+	cached.UpdatedAt = time.Now().UTC()
+
+	if err := s.cache.put(cached); err != nil {
+		return err
+	}
+	s.render(it, cached)
+	return nil
+}
+
+// render writes the entries of cached to the file of it. Group members are
+// merged into a single set of entries, as the store does for formats other
+// than JSON. Failures are logged; the file keeps its previous content.
+func (s *sidecar) render(it *item, cached *cachedItem) {
+	if it.file == "" {
+		return
+	}
+
+	entries, err := entriesOf(cached)
+	if err != nil {
+		log.Printf("rendering %s: %v", it.key(), err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := format.Encode(&buf, it.format, map[string]string(entries)); err != nil {
+		log.Printf("rendering %s: %v", it.key(), err)
+		return
+	}
+	if err := writeFile(it.file, buf.Bytes(), s.mode); err != nil {
+		log.Printf("rendering %s: %v", it.key(), err)
+	}
+}
+
+func entriesOf(cached *cachedItem) (cs.Entries, error) {
+	if cached.Config != nil {
+		return cached.Config.Entries, nil
+	}
+
+	// Members setting a key to different values can't be merged without
+	// losing one of them.
+	merged := make(cs.Entries)
+	for _, config := range cached.Group.Configs {
+		for k, v := range config.Entries {
+			if prev, ok := merged[k]; ok && prev != v {
+				return nil, fmt.Errorf("members set %q to different values", k)
+			}
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// router serves the cached versions under the paths of the store, so an
+// application can be pointed at the sidecar instead.
+func (s *sidecar) router() http.Handler {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	router.HandleFunc("/config/{id}/{ver}", s.getConfigHandler).Methods("GET")
+	router.HandleFunc("/group/{id}/{ver}/", s.getGroupHandler).Methods("GET")
+	router.HandleFunc("/group/{id}/{ver}/config/", s.getGroupConfigsHandler).Methods("GET")
+	router.HandleFunc("/status", s.statusHandler).Methods("GET")
+	return router
+}
+
+// cached returns the cached version a request is for, answering the
+// request itself if there is none.
+func (s *sidecar) cached(w http.ResponseWriter, req *http.Request, kind string) *cachedItem {
+	vars := mux.Vars(req)
+	cached := s.cache.get(itemKey(kind, vars["id"], vars["ver"]))
+	if cached == nil {
+		http.Error(w, "Not cached by this sidecar", http.StatusNotFound)
+		return nil
+	}
+
+	if cached.Stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	w.Header().Set("Last-Modified", cached.UpdatedAt.Format(http.TimeFormat))
+	return cached
+}
+
+func (s *sidecar) getConfigHandler(w http.ResponseWriter, req *http.Request) {
+	cached := s.cached(w, req, cs.ResourceConfig)
+	if cached == nil {
+		return
+	}
+
+	f := format.JSON
+	if name := req.URL.Query().Get("format"); name != "" {
+		var err error
+		if f, err = format.FromName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	if f == format.JSON {
+		writeJSON(w, cached.Config)
+		return
+	}
+	writeFormat(w, f, map[string]string(cached.Config.Entries))
+}
+
+func (s *sidecar) getGroupHandler(w http.ResponseWriter, req *http.Request) {
+	cached := s.cached(w, req, cs.ResourceGroup)
+	if cached == nil {
+		return
+	}
+	writeJSON(w, cached.Group)
+}
+
+// reservedParams are the query parameters the store never takes for
+// labels.
+var reservedParams = map[string]bool{
+	"format": true,
+	"shape":  true,
+	"wait":   true,
+	"index":  true,
+}
+
+// getGroupConfigsHandler returns the members of a cached group labelled with
+// exactly the labels given as query parameters, as the store does.
+func (s *sidecar) getGroupConfigsHandler(w http.ResponseWriter, req *http.Request) {
+	cached := s.cached(w, req, cs.ResourceGroup)
+	if cached == nil {
+		return
+	}
+
+	labels := make(map[string]string)
+	for k, v := range req.URL.Query() {
+		if !reservedParams[k] {
+			labels[k] = v[0]
+		}
+	}
+
+	configs := []*cs.GroupConfig{}
+	for _, config := range cached.Group.Configs {
+		if config.HasLabels(labels) {
+			configs = append(configs, config)
+		}
+	}
+	writeJSON(w, configs)
+}
+
+// statusHandler lists the cached versions and whether they are stale.
+func (s *sidecar) statusHandler(w http.ResponseWriter, req *http.Request) {
+	type status struct {
+		Kind      string    `json:"kind"`
+		ID        string    `json:"id"`
+		Version   string    `json:"version"`
+		Index     uint64    `json:"index"`
+		UpdatedAt time.Time `json:"updatedAt"`
+		Stale     bool      `json:"stale"`
+	}
+
+	statuses := []status{}
+	for _, cached := range s.cache.list() {
+		statuses = append(statuses, status{cached.Kind, cached.ID, cached.Version, cached.Index, cached.UpdatedAt, cached.Stale})
+	}
+	writeJSON(w, statuses)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func writeFormat(w http.ResponseWriter, f string, v interface{}) {
+	var buf bytes.Buffer
+	err := format.Encode(&buf, f, v)
+	if errors.Is(err, format.ErrKeyCollision) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType(f))
+	w.Write(buf.Bytes())
+}
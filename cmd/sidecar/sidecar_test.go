@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestReloadedCacheIsStale(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := openCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &cs.Config{ID: "a/b", Version: "v1", Entries: cs.Entries{"k": "v"}}
+	if err := c.put(&cachedItem{Kind: cs.ResourceConfig, ID: config.ID, Version: "v1", Index: 4, Config: config}); err != nil {
+		t.Fatal(err)
+	}
+	key := itemKey(cs.ResourceConfig, "a/b", "v1")
+	if c.get(key).Stale {
+		t.Error("version received from the store is stale")
+	}
+
+	reloaded, err := openCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := reloaded.get(key)
+	if cached == nil {
+		t.Fatalf("reloaded cache holds %v, want %s", reloaded.list(), key)
+	}
+	if !cached.Stale || cached.Index != 4 || cached.Config.Entries["k"] != "v" {
+		t.Errorf("reloaded %+v, want the stale version at index 4", cached)
+	}
+
+	reloaded.setStale(key, false)
+	if reloaded.get(key).Stale || !cached.Stale {
+		t.Error("setStale changed the cached version in place")
+	}
+}
+
+func TestWriteFileReplacesTheFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app", "config.env")
+
+	for _, content := range []string{"k=\"1\"\n", "k=\"2\"\n"} {
+		if err := writeFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("file holds %q, want %q", data, content)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("file has mode %v, want 0640", info.Mode().Perm())
+	}
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("directory holds %d files, want only the written one", len(files))
+	}
+}
+
+func TestEntriesOfMergesMembers(t *testing.T) {
+	group := &cs.Group{Configs: []*cs.GroupConfig{
+		{Labels: map[string]string{"env": "dev"}, Entries: cs.Entries{"db.host": "x", "log": "info"}},
+		{Labels: map[string]string{"env": "prod"}, Entries: cs.Entries{"log": "info", "port": "80"}},
+	}}
+
+	entries, err := entriesOf(&cachedItem{Group: group})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("merged %v, want 3 entries", entries)
+	}
+
+	group.Configs[1].Entries["log"] = "debug"
+	if _, err := entriesOf(&cachedItem{Group: group}); err == nil {
+		t.Error("merged members setting log to different values")
+	}
+}
+
+func TestGroupConfigsMatchTheExactLabelSet(t *testing.T) {
+	c, err := openCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := &cs.Group{ID: "g", Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: map[string]string{}, Entries: cs.Entries{"k": "unlabelled"}},
+		{Labels: map[string]string{"env": "dev"}, Entries: cs.Entries{"k": "dev"}},
+		{Labels: map[string]string{"env": "dev", "region": "eu"}, Entries: cs.Entries{"k": "dev-eu"}},
+	}}
+	if err := c.put(&cachedItem{Kind: cs.ResourceGroup, ID: "g", Version: "v1", Group: group}); err != nil {
+		t.Fatal(err)
+	}
+	s := &sidecar{cache: c}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"unlabelled"}},
+		{"?env=dev", []string{"dev"}},
+		{"?env=dev&format=json", []string{"dev"}},
+		{"?region=eu&env=dev", []string{"dev-eu"}},
+		{"?region=eu", nil},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/group/g/v1/config/"+test.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: answered %d", test.query, w.Code)
+		}
+
+		var configs []*cs.GroupConfig
+		if err := json.NewDecoder(w.Body).Decode(&configs); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, config := range configs {
+			got = append(got, config.Entries["k"])
+		}
+		if len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+			t.Errorf("%q: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Ways Import handles records whose key already exists.
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportFail      = "fail"
+)
+
+// txnLimit is the maximum number of operations in a single transaction.
+const txnLimit = 64
+
+// ImportResult counts what Import did with the records it was given.
+type ImportResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// Export passes every config, group and label index record in the store to
+// fn, and stops at the first error fn returns. The records are read one
+// config or group at a time, with all its versions, so that the whole store
+// is never held in memory. Each config and group is read as it was at one
+// point, but not all of them at the same point; a consistent copy of the
+// whole store is a snapshot.
+func (cs *ConfigStore) Export(ctx context.Context, fn func(*Record) error) error {
+	span := tracer.StartSpanFromContext(ctx, "Export")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+
+	for _, prefix := range []string{allConfigs + "/", allGroups + "/"} {
+		// With a separator, the keys below an ID come back as the single
+		// prefix "<kind>/<id>/".
+		ids, _, err := kv.Keys(prefix, "/", nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return unavailable(err)
+		}
+
+		for _, id := range ids {
+			// A key right below the prefix would select the IDs it
+			// prefixes too when listed.
+			var pairs api.KVPairs
+			if strings.HasSuffix(id, "/") {
+				pairs, _, err = kv.List(id, nil)
+			} else {
+				var pair *api.KVPair
+				pair, _, err = kv.Get(id, nil)
+				if pair != nil {
+					pairs = api.KVPairs{pair}
+				}
+			}
+			if err != nil {
+				tracer.LogError(span, err)
+				return unavailable(err)
+			}
+
+			for _, pair := range pairs {
+				err := fn(&Record{Key: pair.Key, Value: pair.Value})
+				if err != nil {
+					tracer.LogError(span, err)
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Import writes records exported by Export back into the store. Records whose
+// key already exists are skipped, overwritten or make the whole import fail
+// with ErrExists, depending on mode. Only drafts are overwritten: published
+// and deprecated versions are immutable, and overwriting one of them, or its
+// label index, makes the import fail with ErrImmutable. Every config and
+// group version written is reported by an event, like any other write.
+//
+// All records are checked before any is written. Large imports are still
+// written in several transactions, though, so if one of them fails the
+// records written by those before it stay. The result returned with the
+// error counts them.
+func (cs *ConfigStore) Import(ctx context.Context, records []*Record, mode string) (*ImportResult, error) {
+	span := tracer.StartSpanFromContext(ctx, "Import")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if mode != ImportSkip && mode != ImportOverwrite && mode != ImportFail {
+		return nil, fmt.Errorf("%w: unknown import mode %q", ErrValidation, mode)
+	}
+
+	kv := cs.cli.KV()
+
+	existing := make(map[string]bool)
+	for _, prefix := range []string{allConfigs + "/", allGroups + "/"} {
+		keys, _, err := kv.Keys(prefix, "", nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, unavailable(err)
+		}
+
+		for _, key := range keys {
+			existing[key] = true
+		}
+	}
+
+	result := &ImportResult{}
+	var ops api.KVTxnOps
+	// events holds the event reporting each operation, if any, and created
+	// whether it creates its key.
+	var events []*Event
+	var created []bool
+	for _, record := range records {
+		if !strings.HasPrefix(record.Key, allConfigs+"/") && !strings.HasPrefix(record.Key, allGroups+"/") {
+			return nil, fmt.Errorf("%w: record %q is not a config or group record", ErrValidation, record.Key)
+		}
+
+		if !existing[record.Key] {
+			event, err := importEvent(record, EventCreate)
+			if err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+
+			// Index 0 makes the check-and-set fail if the key was created
+			// after we listed the existing ones.
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: record.Key, Value: record.Value, Index: 0})
+			events = append(events, event)
+			created = append(created, true)
+			continue
+		}
+
+		switch mode {
+		case ImportFail:
+			return nil, fmt.Errorf("%w: %q", ErrExists, record.Key)
+		case ImportSkip:
+			result.Skipped++
+		case ImportOverwrite:
+			event, err := importEvent(record, EventUpdate)
+			if err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+
+			op, err := cs.overwriteOp(childCtx, record)
+			if err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+
+			ops = append(ops, op)
+			events = append(events, event)
+			created = append(created, false)
+		}
+	}
+
+	// Large imports don't fit into a single transaction and are written in
+	// chunks, so only each chunk is applied atomically. The events of a
+	// chunk take one more operation.
+	for start := 0; start < len(ops); start += txnLimit - 1 {
+		end := start + txnLimit - 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		var reported []*Event
+		for _, event := range events[start:end] {
+			if event != nil {
+				reported = append(reported, event)
+			}
+		}
+
+		err := cs.commit(childCtx, ops[start:end], reported...)
+		if err != nil {
+			tracer.LogError(span, err)
+			return result, err
+		}
+
+		for _, c := range created[start:end] {
+			if c {
+				result.Created++
+			} else {
+				result.Overwritten++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// overwriteOp returns the operation that overwrites the stored record under
+// the key of record, provided the version it belongs to is a draft. The
+// write fails if the record changes after it was checked.
+func (cs *ConfigStore) overwriteOp(ctx context.Context, record *Record) (*api.KVTxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "overwriteOp")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	current, _, err := kv.Get(record.Key, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+	if current == nil {
+		// Deleted since the existing keys were listed.
+		return &api.KVTxnOp{Verb: api.KVCAS, Key: record.Key, Value: record.Value, Index: 0}, nil
+	}
+
+	// A label index is as immutable as its group.
+	version := current
+	if parts := strings.SplitN(record.Key, "/", 4); parts[0] == allGroups && !isGroupKey(record.Key) {
+		version, _, err = kv.Get(strings.Join(parts[:3], "/"), nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, unavailable(err)
+		}
+	}
+
+	if version != nil {
+		var stored struct {
+			State string `json:"state"`
+		}
+		err = json.Unmarshal(version.Value, &stored)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if state := storedState(stored.State); state != Draft {
+			return nil, fmt.Errorf("%w: %q belongs to a %s version", ErrImmutable, record.Key, state)
+		}
+	}
+
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: record.Key, Value: record.Value, Index: current.ModifyIndex}, nil
+}
+
+// importEvent returns the event reporting that record was written by an
+// import, or nil for a label index record. It fails with ErrValidation if
+// the record doesn't hold what its key names.
+func importEvent(record *Record, op string) (*Event, error) {
+	parts := strings.SplitN(record.Key, "/", 3)
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("%w: record %q is not a config or group record", ErrValidation, record.Key)
+	}
+
+	if parts[0] == allConfigs {
+		config := &Config{}
+		err := json.Unmarshal(record.Value, config)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %q: %v", ErrValidation, record.Key, err)
+		}
+		config.ID, config.Version = parts[1], parts[2]
+		return configEvent(op, config), nil
+	}
+	if !isGroupKey(record.Key) {
+		if !json.Valid(record.Value) {
+			return nil, fmt.Errorf("%w: record %q is not JSON", ErrValidation, record.Key)
+		}
+		return nil, nil
+	}
+
+	group := &Group{}
+	err := unmarshalGroup(record.Value, group)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %q: %v", ErrValidation, record.Key, err)
+	}
+	group.ID, group.Version = parts[1], parts[2]
+	return groupEvent(op, group), nil
+}
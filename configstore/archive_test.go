@@ -0,0 +1,161 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func exportAll(t *testing.T, store *ConfigStore) []*Record {
+	t.Helper()
+
+	var records []*Record
+	err := store.Export(context.Background(), func(record *Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestImportReportsEveryConfigAndGroupWritten(t *testing.T) {
+	source, _ := newTestStore(t)
+	ctx := context.Background()
+
+	n := txnLimit + 10
+	for i := 0; i < n; i++ {
+		createConfig(t, source, "c"+strconv.Itoa(i), "v1")
+	}
+	group, err := source.CreateGroup(ctx, &Group{Version: "v1", Configs: members(3, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := exportAll(t, source)
+
+	target, _ := newTestStore(t)
+	result, err := target.Import(ctx, records, ImportFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*Event
+	target.OnEvent("test", func(event *Event) error {
+		events = append(events, event)
+		return nil
+	})
+	if _, err := target.DispatchOutbox(ctx, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != n+1 {
+		t.Fatalf("import of %d records reported %d events, want %d", result.Created, len(events), n+1)
+	}
+	for _, event := range events {
+		if event.Operation != EventCreate {
+			t.Errorf("imported %s %s reported as %q", event.Resource, event.ID, event.Operation)
+		}
+		if event.Resource == ResourceGroup && (event.ID != group.ID || len(event.Labels) != 3) {
+			t.Errorf("imported group reported as %s with %d labels", event.ID, len(event.Labels))
+		}
+	}
+}
+
+func TestImportWritesNothingWhenARecordIsInvalid(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	var records []*Record
+	for i := 0; i < txnLimit+10; i++ {
+		records = append(records, &Record{Key: "config/c" + strconv.Itoa(i) + "/v1", Value: []byte(`{"entries":{"k":"v"}}`)})
+	}
+	records = append(records, &Record{Key: "group/g/v1", Value: []byte("not json")})
+
+	if _, err := store.Import(ctx, records, ImportFail); !errors.Is(err, ErrValidation) {
+		t.Fatalf("importing an invalid record: got %v, want ErrValidation", err)
+	}
+	if keys := fake.Keys(allConfigs + "/"); len(keys) != 0 {
+		t.Errorf("import of an invalid record wrote %d configs", len(keys))
+	}
+}
+
+func TestExportPassesEveryRecordOnce(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	// IDs prefixing one another must not be listed twice.
+	for _, id := range []string{"c", "c1", "c10"} {
+		createConfig(t, store, id, "v1")
+		createConfig(t, store, id, "v2")
+	}
+	if _, err := store.CreateGroup(ctx, &Group{Version: "v1", Configs: members(3, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, record := range exportAll(t, store) {
+		keys = append(keys, record.Key)
+	}
+	want := append(fake.Keys(allConfigs+"/"), fake.Keys(allGroups+"/")...)
+	if !equalStrings(keys, want) {
+		t.Errorf("exported %v, want %v", keys, want)
+	}
+
+	stop := errors.New("stop")
+	var n int
+	err := store.Export(ctx, func(*Record) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("export went on after an error: got %v after %d records", err, n)
+	}
+}
+
+func TestImportOverwritesOnlyDrafts(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	draft := createConfig(t, store, "draft", "v1")
+	createConfig(t, store, "published", "v1")
+	if _, err := store.SetConfigState(ctx, "published", "v1", Published, 0); err != nil {
+		t.Fatal(err)
+	}
+	group, err := store.CreateGroup(ctx, &Group{Version: "v1", State: Published, Configs: members(2, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overwrite := func(key string) error {
+		_, err := store.Import(ctx, []*Record{{Key: key, Value: []byte(`{"entries":{"k":"imported"}}`)}}, ImportOverwrite)
+		return err
+	}
+
+	for _, key := range []string{"config/published/v1", "group/" + group.ID + "/v1", "group/" + group.ID + "/v1/labels"} {
+		if err := overwrite(key); !errors.Is(err, ErrImmutable) {
+			t.Errorf("overwriting %s: got %v, want ErrImmutable", key, err)
+		}
+	}
+	published, err := store.FindConf(ctx, "published", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published.Entries["k"] != "v" || published.State != Published {
+		t.Errorf("refused import changed the published version to %+v", published)
+	}
+
+	if err := overwrite("config/draft/v1"); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := store.FindConf(ctx, "draft", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Entries["k"] != "imported" || imported.Index == draft.Index {
+		t.Errorf("draft overwritten to %+v", imported)
+	}
+}
@@ -0,0 +1,197 @@
+package configstore
+
+import (
+	"context"
+	"fmt"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Operations and resources of a batch.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+
+	ResourceConfig = "config"
+	ResourceGroup  = "group"
+)
+
+// BatchOp is a single write of a batch. Creating with an ID adds a new
+// version to an existing config or group, updating replaces the entries or
+// members of a draft version. A non-zero Index must equal the current index
+// of the version that is updated or deleted.
+type BatchOp struct {
+	Op       string         `json:"op" yaml:"op" toml:"op"`
+	Resource string         `json:"resource" yaml:"resource" toml:"resource"`
+	ID       string         `json:"id,omitempty" yaml:"id,omitempty" toml:"id,omitempty"`
+	Version  string         `json:"version" yaml:"version" toml:"version"`
+	State    string         `json:"state,omitempty" yaml:"state,omitempty" toml:"state,omitempty"`
+	Entries  Entries        `json:"entries,omitempty" yaml:"entries,omitempty" toml:"entries,omitempty"`
+	Configs  []*GroupConfig `json:"configs,omitempty" yaml:"configs,omitempty" toml:"configs,omitempty"`
+	Index    uint64         `json:"index,omitempty" yaml:"index,omitempty" toml:"index,omitempty"`
+}
+
+// BatchResult is the outcome of a BatchOp, Err being nil when it went
+// through.
+type BatchResult struct {
+	Op       string `json:"op"`
+	Resource string `json:"resource"`
+	ID       string `json:"id,omitempty"`
+	Version  string `json:"version"`
+	Err      error  `json:"-"`
+}
+
+// Batch executes ops and reports the result of each. Operations see the
+// store as it was before the batch. When all of them fit in a single
+// transaction the batch is atomic: either every operation is applied or
+// none is. Larger batches are applied operation by operation, and atomic
+// is false.
+//
+// An idempotency key carried by ctx, see WithRequestId, is reserved by the
+// transaction of an atomic batch. A larger batch reserves it before applying
+// any operation, and records the operations that were applied after.
+func (cs *ConfigStore) Batch(ctx context.Context, ops []*BatchOp) (results []*BatchResult, atomic bool) {
+	span := tracer.StartSpanFromContext(ctx, "Batch")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	results = make([]*BatchResult, len(ops))
+	txns := make([]api.KVTxnOps, len(ops))
+	events := make([]*Event, len(ops))
+	total, failed := 0, false
+	for i, op := range ops {
+		results[i] = &BatchResult{Op: op.Op, Resource: op.Resource, ID: op.ID, Version: op.Version}
+
+		txn, event, err := cs.batchOps(childCtx, op, results[i])
+		if err != nil {
+			tracer.LogError(span, err)
+			results[i].Err = err
+			failed = true
+			continue
+		}
+		txns[i] = txn
+		events[i] = event
+		total += len(txn)
+	}
+
+	// The events of a transaction add a single outbox record, and the
+	// idempotency key another operation.
+	reserved := 0
+	if requestIdFrom(ctx) != "" {
+		reserved = 1
+	}
+	if total+1+reserved > txnLimit {
+		var planned []*Event
+		for i, event := range events {
+			if results[i].Err == nil {
+				planned = append(planned, event)
+			}
+		}
+
+		err := cs.ReserveRequest(childCtx, planned...)
+		if err != nil {
+			tracer.LogError(span, err)
+			for _, result := range results {
+				if result.Err == nil {
+					result.Err = err
+				}
+			}
+			return results, false
+		}
+
+		var applied []*Event
+		for i, txn := range txns {
+			if results[i].Err != nil {
+				continue
+			}
+
+			err := cs.commit(WithRequestId(childCtx, ""), txn, events[i])
+			if err != nil {
+				tracer.LogError(span, err)
+				results[i].Err = err
+				continue
+			}
+			applied = append(applied, events[i])
+		}
+
+		// The operations were applied either way, so this can only be
+		// logged.
+		if err := cs.recordRequest(childCtx, newRequest(applied, true)); err != nil {
+			tracer.LogError(span, err)
+		}
+		return results, false
+	}
+
+	if failed {
+		for _, result := range results {
+			if result.Err == nil {
+				result.Err = ErrAborted
+			}
+		}
+		return results, true
+	}
+
+	var all api.KVTxnOps
+	for _, txn := range txns {
+		all = append(all, txn...)
+	}
+
+	err := cs.commit(childCtx, all, events...)
+	if err != nil {
+		tracer.LogError(span, err)
+		for _, result := range results {
+			result.Err = err
+		}
+		return results, true
+	}
+
+	return results, true
+}
+
+// batchOps returns the transaction operations of op and the event reporting
+// it, filling in the ID of what it creates.
+func (cs *ConfigStore) batchOps(ctx context.Context, op *BatchOp, result *BatchResult) (api.KVTxnOps, *Event, error) {
+	span := tracer.StartSpanFromContext(ctx, "batchOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	switch {
+	case op.Op == OpCreate && op.Resource == ResourceConfig:
+		config := &Config{ID: op.ID, Version: op.Version, Entries: op.Entries, State: op.State}
+		ops, err := cs.createConfigOps(childCtx, config)
+		result.ID = config.ID
+		return ops, configEvent(EventCreate, config), err
+	case op.Op == OpCreate && op.Resource == ResourceGroup:
+		group := &Group{ID: op.ID, Version: op.Version, Configs: op.Configs, State: op.State}
+		ops, err := cs.createGroupOps(childCtx, group)
+		result.ID = group.ID
+		return ops, groupEvent(EventCreate, group), err
+	case op.Op == OpUpdate && op.Resource == ResourceConfig:
+		config, ops, err := cs.updateConfigOps(childCtx, &Config{ID: op.ID, Version: op.Version, Entries: op.Entries, Index: op.Index})
+		if err != nil {
+			return nil, nil, err
+		}
+		return ops, configEvent(EventUpdate, config), nil
+	case op.Op == OpUpdate && op.Resource == ResourceGroup:
+		event, ops, err := cs.updateGroupOps(childCtx, &Group{ID: op.ID, Version: op.Version, Configs: op.Configs, Index: op.Index})
+		return ops, event, err
+	case op.Op == OpDelete && op.Resource == ResourceConfig:
+		config, ops, err := cs.removeConfigOps(childCtx, op.ID, op.Version, op.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ops, configEvent(EventDelete, config), nil
+	case op.Op == OpDelete && op.Resource == ResourceGroup:
+		group, ops, err := cs.removeGroupOps(childCtx, op.ID, op.Version, op.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ops, groupEvent(EventDelete, group), nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s %s", ErrInvalidOp, op.Op, op.Resource)
+}
@@ -0,0 +1,55 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBatchAppliesEveryOperation(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	results, atomic := store.Batch(ctx, []*BatchOp{
+		{Op: OpCreate, Resource: ResourceConfig, Version: "v1", Entries: Entries{"k": "v"}},
+		{Op: OpCreate, Resource: ResourceGroup, Version: "v1", Configs: members(3, 0)},
+	})
+	if !atomic {
+		t.Error("a batch of two creates wasn't atomic")
+	}
+	for _, result := range results {
+		if result.Err != nil || result.ID == "" {
+			t.Fatalf("%s %s: id %q, %v", result.Op, result.Resource, result.ID, result.Err)
+		}
+	}
+
+	if _, err := store.FindConf(ctx, results[0].ID, "v1"); err != nil {
+		t.Errorf("created config: %v", err)
+	}
+	if _, err := store.FindGroup(ctx, results[1].ID, "v1"); err != nil {
+		t.Errorf("created group: %v", err)
+	}
+}
+
+func TestBatchAbortsWhenAnOperationFails(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	results, atomic := store.Batch(ctx, []*BatchOp{
+		{Op: OpCreate, Resource: ResourceConfig, Version: "v1", Entries: Entries{"k": "v"}},
+		{Op: OpUpdate, Resource: ResourceConfig, ID: "missing", Version: "v1", Entries: Entries{"k": "v"}},
+	})
+	if !atomic {
+		t.Error("a small batch wasn't atomic")
+	}
+	if !errors.Is(results[0].Err, ErrAborted) {
+		t.Errorf("create answered %v, want %v", results[0].Err, ErrAborted)
+	}
+	if !errors.Is(results[1].Err, ErrNotFound) {
+		t.Errorf("update of a missing config answered %v, want %v", results[1].Err, ErrNotFound)
+	}
+
+	if keys := fake.Keys(allConfigs + "/"); len(keys) != 0 {
+		t.Errorf("aborted batch stored %v", keys)
+	}
+}
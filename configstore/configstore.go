@@ -5,22 +5,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sync"
+	"time"
 
 	tracer "github.com/dekeract10/ARS-projekat/tracer"
-	"github.com/google/uuid"
 	"github.com/hashicorp/consul/api"
 )
 
 type ConfigStore struct {
-	cli *api.Client
+	cli       *api.Client
+	retention time.Duration
+
+	mu        sync.Mutex
+	listeners []listener
 }
 
+const defaultRetention = 30 * 24 * time.Hour
+
 func New() (*ConfigStore, error) {
 	db := os.Getenv("DB")
 	dbport := os.Getenv("DBPORT")
 
+	retention := defaultRetention
+	if r := os.Getenv("TRASH_RETENTION"); r != "" {
+		d, err := time.ParseDuration(r)
+		if err != nil {
+			return nil, err
+		}
+		retention = d
+	}
+
 	config := api.DefaultConfig()
 	config.Address = fmt.Sprintf("%s:%s", db, dbport)
 	client, err := api.NewClient(config)
@@ -29,7 +44,8 @@ func New() (*ConfigStore, error) {
 	}
 
 	return &ConfigStore{
-		cli: client,
+		cli:       client,
+		retention: retention,
 	}, nil
 }
 
@@ -39,54 +55,134 @@ func (cs *ConfigStore) FindConf(ctx context.Context, id string, ver string) (*Co
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	key := constructConfigKey(childCtx, id, ver)
-	data, _, err := kv.Get(key, nil)
+	config, _, err := cs.WatchConf(childCtx, id, ver, 0, 0)
+	return config, err
+}
+
+// DeleteConfig moves a config version to the trash. A non-zero match must
+// equal the version's current index for the delete to go through.
+func (cs *ConfigStore) DeleteConfig(ctx context.Context, id, ver string, match uint64) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	if err != nil || data == nil {
+	conf, ops, err := cs.removeConfigOps(childCtx, id, ver, match)
+	if err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("That item does not exist!")
+		return nil, err
 	}
 
-	config := &Config{}
-	err = json.Unmarshal(data.Value, config)
+	err = cs.commit(childCtx, ops, configEvent(EventDelete, conf))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return config, nil
+	return map[string]string{"Deleted config": id + ver}, nil
 }
 
-func (cs *ConfigStore) DeleteConfig(ctx context.Context, id, ver string) (map[string]string, error) {
-	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
+// removeConfigOps returns the operations that move a config version to the
+// trash, checking the version against match and its state first.
+func (cs *ConfigStore) removeConfigOps(ctx context.Context, id, ver string, match uint64) (*Config, api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "removeConfigOps")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	_, err := kv.Delete(constructConfigKey(childCtx, id, ver), nil)
+	conf, err := cs.FindConf(childCtx, id, ver)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return map[string]string{"Deleted config": id + ver}, nil
+	if match != 0 && match != conf.Index {
+		return nil, nil, ErrPreconditionFailed
+	}
+
+	if conf.State == Published {
+		return nil, nil, ErrImmutable
+	}
+
+	ops, err := cs.deleteConfigOps(childCtx, conf)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	return conf, ops, nil
 }
 
-func (cs *ConfigStore) DeleteConfigGroup(ctx context.Context, id, ver string) (map[string]string, error) {
-	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
+// DeleteConfigVersions moves every version of a config to the trash. Unless
+// force is set it refuses to delete a config that has published versions.
+func (cs *ConfigStore) DeleteConfigVersions(ctx context.Context, id string, force bool) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteConfigVersions")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	_, err := kv.Delete(constructGroupKey(childCtx, id, ver), nil)
+	configs, err := cs.FindConfVersions(childCtx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	if len(configs) == 0 {
+		return ErrNotFound
+	}
+
+	if !force {
+		for _, conf := range configs {
+			if conf.State == Published {
+				return ErrPublished
+			}
+		}
+	}
+
+	// Versions are committed one by one so the number of versions isn't
+	// bound by the size limit of a single transaction.
+	for _, conf := range configs {
+		ops, err := cs.deleteConfigOps(childCtx, conf)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+
+		err = cs.commit(childCtx, ops, configEvent(EventDelete, conf))
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteConfigOps returns the operations that move a config version to the
+// trash, provided it hasn't changed since it was read.
+func (cs *ConfigStore) deleteConfigOps(ctx context.Context, conf *Config) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "deleteConfigOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	key := constructConfigKey(childCtx, conf.ID, conf.Version)
+	data, err := json.Marshal(conf)
 	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	trashOp, err := cs.trash(childCtx, constructTrashConfigKey(childCtx, conf.ID, conf.Version), []*Record{{Key: key, Value: data}})
+	if err != nil {
+		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return map[string]string{"Deleted group": id + ver}, nil
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: key, Index: conf.Index},
+		trashOp,
+	}, nil
 }
 
 func (cs *ConfigStore) FindConfVersions(ctx context.Context, id string) ([]*Config, error) {
@@ -97,11 +193,12 @@ func (cs *ConfigStore) FindConfVersions(ctx context.Context, id string) ([]*Conf
 
 	kv := cs.cli.KV()
 
-	key := constructConfigIdKey(childCtx, id)
-	data, _, err := kv.List(key, nil)
+	// Without the trailing slash the prefix would also match every config
+	// whose ID merely starts with id.
+	data, _, err := kv.List(constructConfigIdKey(childCtx, id)+"/", nil)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, unavailable(err)
 	}
 
 	var configs []*Config
@@ -113,6 +210,8 @@ func (cs *ConfigStore) FindConfVersions(ctx context.Context, id string) ([]*Conf
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.State = storedState(config.State)
+		config.Index = pair.ModifyIndex
 
 		configs = append(configs, config)
 	}
@@ -124,21 +223,16 @@ func (cs *ConfigStore) CreateConfig(ctx context.Context, config *Config) (*Confi
 	span := tracer.StartSpanFromContext(ctx, "CreateConfig")
 	defer span.Finish()
 
-	kv := cs.cli.KV()
-
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	sid, rid := generateConfigKey(childCtx, config.Version)
-	config.ID = rid
-
-	data, err := json.Marshal(config)
+	config.ID = ""
+	ops, err := cs.createConfigOps(childCtx, config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	c := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(c, nil)
+	config.Index, err = cs.commitIndex(childCtx, constructConfigKey(childCtx, config.ID, config.Version), ops, configEvent(EventCreate, config))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -148,35 +242,67 @@ func (cs *ConfigStore) CreateConfig(ctx context.Context, config *Config) (*Confi
 }
 
 func (cs *ConfigStore) UpdateConfigVersion(ctx context.Context, config *Config) (*Config, error) {
-	span := tracer.StartSpanFromContext(context.Background(), "UpdateConfigVersion")
+	span := tracer.StartSpanFromContext(ctx, "UpdateConfigVersion")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
+	ops, err := cs.createConfigOps(childCtx, config)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
-	data, err := json.Marshal(config)
+	config.Index, err = cs.commitIndex(childCtx, constructConfigKey(childCtx, config.ID, config.Version), ops, configEvent(EventCreate, config))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	_, err = cs.FindConf(childCtx, config.ID, config.Version)
+	return config, nil
+}
+
+// createConfigOps returns the operations that store a new config version.
+// A config without an ID gets a fresh one, otherwise the version is added
+// to the existing config and must not exist yet.
+func (cs *ConfigStore) createConfigOps(ctx context.Context, config *Config) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "createConfigOps")
+	defer span.Finish()
 
-	//Does exist
-	if err == nil {
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if err := config.Entries.validate(); err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("Given config version already exists! ")
+		return nil, err
 	}
 
-	c := &api.KVPair{Key: constructConfigKey(childCtx, config.ID, config.Version), Value: data}
-	_, err = kv.Put(c, nil)
+	state, err := initialState(config.State)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	config.State = state
+
+	var key string
+	if config.ID == "" {
+		key, config.ID = generateConfigKey(childCtx, config.Version)
+	} else {
+		if _, err := cs.FindConf(childCtx, config.ID, config.Version); err == nil {
+			return nil, ErrExists
+		}
+		key = constructConfigKey(childCtx, config.ID, config.Version)
+	}
+
+	data, err := json.Marshal(config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
-	return config, nil
 
+	// Index 0 makes the write fail if the version was created meanwhile.
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: data, Index: 0},
+	}, nil
 }
 
 func (cs *ConfigStore) CreateGroup(ctx context.Context, group *Group) (*Group, error) {
@@ -185,10 +311,52 @@ func (cs *ConfigStore) CreateGroup(ctx context.Context, group *Group) (*Group, e
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
+	group.ID = ""
+	ops, err := cs.createGroupOps(childCtx, group)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	group.Index, err = cs.commitIndex(childCtx, constructGroupKey(childCtx, group.ID, group.Version), ops, groupEvent(EventCreate, group))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// createGroupOps returns the operations that store a new group version
+// together with its label index. Like createConfigOps it starts a new group
+// when group.ID is empty.
+func (cs *ConfigStore) createGroupOps(ctx context.Context, group *Group) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "createGroupOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if err := validateMembers(group.Configs); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	state, err := initialState(group.State)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	group.State = state
 
-	sid, rid := generateGroupKey(childCtx, group.Version)
-	group.ID = rid
+	var key string
+	if group.ID == "" {
+		key, group.ID = generateGroupKey(childCtx, group.Version)
+	} else {
+		if _, err := cs.FindGroup(childCtx, group.ID, group.Version); err == nil {
+			return nil, ErrExists
+		}
+		key = constructGroupKey(childCtx, group.ID, group.Version)
+	}
 
 	data, err := json.Marshal(group)
 	if err != nil {
@@ -196,136 +364,294 @@ func (cs *ConfigStore) CreateGroup(ctx context.Context, group *Group) (*Group, e
 		return nil, err
 	}
 
-	g := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(g, nil)
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: data, Index: 0},
+	}
+
+	labels, err := labelOps(childCtx, group.Configs, group.ID, group.Version)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	err = cs.CreateLabels(childCtx, group.Configs, group.ID, group.Version)
+	return append(ops, labels...), nil
+}
+
+// labelIndex maps the encoded label sets of group members to the members
+// carrying them.
+type labelIndex map[string][]*GroupConfig
+
+// labelOps returns the operation that indexes the members of a group version
+// by their labels. The whole index is a single record, so that writing a
+// group takes the same few operations however many members it has, well
+// within the size limit of a transaction.
+func labelOps(ctx context.Context, configs []*GroupConfig, id, ver string) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "labelOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	index := make(labelIndex)
+	for _, config := range configs {
+		key := encodeLabels(config.Labels)
+		index[key] = append(index[key], config)
+	}
+
+	data, err := json.Marshal(index)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return group, nil
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVSet, Key: constructGroupIndexKey(childCtx, id, ver), Value: data},
+	}, nil
 }
 
-func (cs *ConfigStore) CreateLabels(ctx context.Context, configs []map[string]string, id, ver string) error {
-	span := tracer.StartSpanFromContext(ctx, "CreateLabels")
+// AddLabelsToGroup adds configs to a draft group version and returns its
+// members along with the index the version is at now.
+func (cs *ConfigStore) AddLabelsToGroup(ctx context.Context, configs []*GroupConfig, id, ver string, match uint64) ([]*GroupConfig, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "AddLabelsToGroup")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	if keys, _, err := kv.Get(constructGroupKey(childCtx, id, ver), nil); err != nil || keys == nil {
+	if err := validateMembers(configs); err != nil {
 		tracer.LogError(span, err)
-		return errors.New("Group doesn't exists")
+		return nil, 0, err
 	}
 
-	for _, config := range configs {
-		cid := constructGroupLabel(childCtx, id, ver, uuid.New().String(), config)
-		cdata, err := json.Marshal(config)
+	gr, err := cs.FindGroup(childCtx, id, ver)
+	if err != nil || gr == nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
 
-		log.Default().Printf("adding new config: %q. under key %q", config, cdata)
-		if err != nil {
-			tracer.LogError(span, err)
-			return err
-		}
+	if match != 0 && match != gr.Index {
+		return nil, 0, ErrPreconditionFailed
+	}
 
-		c := &api.KVPair{Key: cid, Value: cdata}
-		_, err = kv.Put(c, nil)
-		if err != nil {
-			tracer.LogError(span, err)
-			return err
-		}
+	if gr.State != Draft {
+		return nil, 0, ErrImmutable
 	}
-	return nil
+
+	gr.Configs = append(gr.Configs, configs...)
+
+	data, err := json.Marshal(gr)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructGroupKey(childCtx, id, ver), Value: data, Index: gr.Index},
+	}
+
+	labels, err := labelOps(childCtx, gr.Configs, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	ops = append(ops, labels...)
+
+	index, err := cs.commitIndex(childCtx, constructGroupKey(childCtx, id, ver), ops, groupEvent(EventUpdate, gr))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	return gr.Configs, index, nil
 }
 
-func (cs *ConfigStore) AddLabelsToGroup(ctx context.Context, configs []map[string]string, id, ver string) ([]map[string]string, error) {
-	span := tracer.StartSpanFromContext(ctx, "AddLabelsToGroup")
+func (cs *ConfigStore) FindLabels(ctx context.Context, id, ver string, labels map[string]string) ([]*GroupConfig, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindLabels")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	configs, _, err := cs.WatchLabels(childCtx, id, ver, labels, 0, 0)
+	return configs, err
+}
+
+// RemoveConfigsFromGroup removes the members carrying labels from a draft
+// group version and returns them along with the index the version is at now.
+func (cs *ConfigStore) RemoveConfigsFromGroup(ctx context.Context, id, ver string, labels map[string]string, match uint64) ([]*GroupConfig, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "RemoveConfigsFromGroup")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
 	gr, err := cs.FindGroup(childCtx, id, ver)
-	if err != nil || gr == nil {
+	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, 0, err
 	}
 
-	for _, config := range configs {
-		log.Default().Printf("%q", configs)
-		gr.Configs = append(gr.Configs, config)
+	if match != 0 && match != gr.Index {
+		return nil, 0, ErrPreconditionFailed
+	}
+
+	if gr.State != Draft {
+		return nil, 0, ErrImmutable
+	}
+
+	var kept, removed []*GroupConfig
+	for _, config := range gr.Configs {
+		if sameLabels(config.Labels, labels) {
+			removed = append(removed, config)
+		} else {
+			kept = append(kept, config)
+		}
 	}
 
+	if len(removed) == 0 {
+		return nil, 0, ErrNotFound
+	}
+
+	gr.Configs = kept
 	data, err := json.Marshal(gr)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, 0, err
+	}
+
+	// The group record is written with check-and-set against the version we
+	// read, so a concurrent change aborts the whole transaction.
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructGroupKey(childCtx, id, ver), Value: data, Index: gr.Index},
+	}
+
+	labelIndex, err := labelOps(childCtx, kept, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
 	}
+	ops = append(ops, labelIndex...)
 
-	sid := constructGroupKey(childCtx, id, ver)
+	index, err := cs.commitIndex(childCtx, constructGroupKey(childCtx, id, ver), ops, groupEvent(EventUpdate, gr, removed...))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
 
-	g := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(g, nil)
+	return removed, index, nil
+}
+
+// UpdateConfig replaces the entries of a draft config version. A non-zero
+// config.Index must equal the version's current index.
+func (cs *ConfigStore) UpdateConfig(ctx context.Context, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	current, ops, err := cs.updateConfigOps(childCtx, config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	err = cs.CreateLabels(childCtx, configs, gr.ID, gr.Version)
+	current.Index, err = cs.commitIndex(childCtx, constructConfigKey(childCtx, current.ID, current.Version), ops, configEvent(EventUpdate, current))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return gr.Configs, nil
+	return current, nil
 }
 
-func (cs *ConfigStore) FindLabels(ctx context.Context, id, ver, kvpairs string) ([]map[string]string, error) {
-	span := tracer.StartSpanFromContext(ctx, "FindLabels")
+// updateConfigOps returns the stored version with the entries of config
+// applied and the operation that writes it back.
+func (cs *ConfigStore) updateConfigOps(ctx context.Context, config *Config) (*Config, api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "updateConfigOps")
 	defer span.Finish()
 
-	kv := cs.cli.KV()
-	labelkey := fmt.Sprintf(group, id, ver, kvpairs) + "/"
-	keys, _, err := kv.List(labelkey, nil)
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if err := config.Entries.validate(); err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	current, err := cs.FindConf(childCtx, config.ID, config.Version)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	configs := make([]map[string]string, len(keys))
-	for i, k := range keys {
-		var config map[string]string
-		json.Unmarshal(k.Value, &config)
-		log.Default().Printf("%q", config)
-		configs[i] = config
+	if config.Index != 0 && config.Index != current.Index {
+		return nil, nil, ErrPreconditionFailed
 	}
 
-	return configs, nil
+	if current.State != Draft {
+		return nil, nil, ErrImmutable
+	}
+
+	current.Entries = config.Entries
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	return current, api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructConfigKey(childCtx, current.ID, current.Version), Value: data, Index: current.Index},
+	}, nil
 }
 
-func (cs *ConfigStore) FindGroup(ctx context.Context, id string, ver string) (*Group, error) {
-	span := tracer.StartSpanFromContext(ctx, "FindGroup")
+func (cs *ConfigStore) SetConfigState(ctx context.Context, id, ver, state string, match uint64) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "SetConfigState")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	key := constructGroupKey(childCtx, id, ver)
-	data, _, err := kv.Get(key, nil)
+	config, err := cs.FindConf(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if match != 0 && match != config.Index {
+		return nil, ErrPreconditionFailed
+	}
+
+	if !canTransition(config.State, state) {
+		return nil, ErrInvalidState
+	}
+	config.State = state
+
+	config.Index, err = cs.putWithIndex(childCtx, constructConfigKey(childCtx, id, ver), config, config.Index, configEvent(EventUpdate, config))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (cs *ConfigStore) SetGroupState(ctx context.Context, id, ver, state string, match uint64) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "SetGroupState")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	if err != nil || data == nil {
+	group, err := cs.FindGroup(childCtx, id, ver)
+	if err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("That item does not exist!")
+		return nil, err
+	}
+
+	if match != 0 && match != group.Index {
+		return nil, ErrPreconditionFailed
 	}
 
-	group := &Group{}
-	err = json.Unmarshal(data.Value, group)
+	if !canTransition(group.State, state) {
+		return nil, ErrInvalidState
+	}
+	group.State = state
+
+	group.Index, err = cs.putWithIndex(childCtx, constructGroupKey(childCtx, id, ver), group, group.Index, groupEvent(EventUpdate, group))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -334,93 +660,359 @@ func (cs *ConfigStore) FindGroup(ctx context.Context, id string, ver string) (*G
 	return group, nil
 }
 
+func (cs *ConfigStore) FindGroup(ctx context.Context, id string, ver string) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	group, _, err := cs.WatchGroup(childCtx, id, ver, 0, 0)
+	return group, err
+}
+
 func (cs *ConfigStore) UpdateGroupVersion(ctx context.Context, group *Group) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "UpdateGroupVersion")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
+	ops, err := cs.createGroupOps(childCtx, group)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
-	data, err := json.Marshal(group)
+	group.Index, err = cs.commitIndex(childCtx, constructGroupKey(childCtx, group.ID, group.Version), ops, groupEvent(EventCreate, group))
 	if err != nil {
+		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	_, err = cs.FindGroup(childCtx, group.ID, group.Version)
+	return group, nil
+}
+
+// updateGroupOps returns the operations that replace the members of a draft
+// group version with those of group, and the event reporting the change.
+func (cs *ConfigStore) updateGroupOps(ctx context.Context, group *Group) (*Event, api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "updateGroupOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	//Does exist
-	if err == nil {
+	if err := validateMembers(group.Configs); err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("Given group version already exists! ")
+		return nil, nil, err
 	}
 
-	c := &api.KVPair{Key: constructGroupKey(childCtx, group.ID, group.Version), Value: data}
-	_, err = kv.Put(c, nil)
+	current, err := cs.FindGroup(childCtx, group.ID, group.Version)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
+	}
+
+	if group.Index != 0 && group.Index != current.Index {
+		return nil, nil, ErrPreconditionFailed
 	}
 
-	err = cs.CreateLabels(childCtx, group.Configs, group.ID, group.Version)
+	if current.State != Draft {
+		return nil, nil, ErrImmutable
+	}
+
+	previous := current.Configs
+	current.Configs = group.Configs
+
+	data, err := json.Marshal(current)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return group, nil
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructGroupKey(childCtx, current.ID, current.Version), Value: data, Index: current.Index},
+	}
+
+	labels, err := labelOps(childCtx, current.Configs, current.ID, current.Version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
 
+	return groupEvent(EventUpdate, current, previous...), append(ops, labels...), nil
 }
 
-func (cs *ConfigStore) DeleteGroup(ctx context.Context, id, ver string) error {
+// DeleteGroup moves a group version and its label index to the trash. A
+// non-zero match must equal the version's current index.
+func (cs *ConfigStore) DeleteGroup(ctx context.Context, id, ver string, match uint64) error {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
+	gr, ops, err := cs.removeGroupOps(childCtx, id, ver, match)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	err = cs.commit(childCtx, ops, groupEvent(EventDelete, gr))
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// removeGroupOps returns the operations that move a group version to the
+// trash, checking the version against match and its state first.
+func (cs *ConfigStore) removeGroupOps(ctx context.Context, id, ver string, match uint64) (*Group, api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "removeGroupOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	gr, err := cs.FindGroup(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	if match != 0 && match != gr.Index {
+		return nil, nil, ErrPreconditionFailed
+	}
+
+	if gr.State == Published {
+		return nil, nil, ErrImmutable
+	}
+
+	ops, err := cs.deleteGroupOps(childCtx, gr)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	return gr, ops, nil
+}
+
+// DeleteGroupVersions moves every version of a group to the trash. Unless
+// force is set it refuses to delete a group that has published versions.
+func (cs *ConfigStore) DeleteGroupVersions(ctx context.Context, id string, force bool) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteGroupVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	groups, err := cs.FindGroupVersions(childCtx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	if len(groups) == 0 {
+		return ErrNotFound
+	}
+
+	if !force {
+		for _, gr := range groups {
+			if gr.State == Published {
+				return ErrPublished
+			}
+		}
+	}
+
+	for _, gr := range groups {
+		ops, err := cs.deleteGroupOps(childCtx, gr)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+
+		err = cs.commit(childCtx, ops, groupEvent(EventDelete, gr))
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cs *ConfigStore) FindGroupVersions(ctx context.Context, id string) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindGroupVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.listGroups(childCtx, constructGroupIdKey(childCtx, id)+"/")
+}
+
+// FindGroups returns every version of every group.
+func (cs *ConfigStore) FindGroups(ctx context.Context) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindGroups")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.listGroups(childCtx, allGroups+"/")
+}
+
+// listGroups returns the group versions stored under prefix.
+func (cs *ConfigStore) listGroups(ctx context.Context, prefix string) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "listGroups")
+	defer span.Finish()
+
 	kv := cs.cli.KV()
 
-	_, err := kv.DeleteTree(constructGroupKey(childCtx, id, ver), nil)
+	data, _, err := kv.List(prefix, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
 
-	return err
+	var groups []*Group
+
+	for _, pair := range data {
+		// The label indexes of the group live below its version keys.
+		if !isGroupKey(pair.Key) {
+			continue
+		}
+
+		group := &Group{}
+		err := unmarshalGroup(pair.Value, group)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		group.State = storedState(group.State)
+		group.Index = pair.ModifyIndex
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
 }
 
-func (cs *ConfigStore) SaveRequestId(ctx context.Context) string {
-	span := tracer.StartSpanFromContext(ctx, "SaveRequestId")
+// deleteGroupOps returns the operations that move a group version and its
+// label index to the trash, provided the group hasn't changed since it was
+// read.
+func (cs *ConfigStore) deleteGroupOps(ctx context.Context, gr *Group) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "deleteGroupOps")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
 	kv := cs.cli.KV()
 
-	reqId := generateRequestId(childCtx)
+	key := constructGroupKey(childCtx, gr.ID, gr.Version)
+	data, err := json.Marshal(gr)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	records := []*Record{{Key: key, Value: data}}
 
-	i := &api.KVPair{Key: reqId, Value: nil}
+	labelsKey := constructGroupLabelsKey(childCtx, gr.ID, gr.Version)
+	labels, _, err := kv.List(labelsKey, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
 
-	_, err := kv.Put(i, nil)
+	for _, pair := range labels {
+		records = append(records, &Record{Key: pair.Key, Value: pair.Value})
+	}
 
+	trashOp, err := cs.trash(childCtx, constructTrashGroupKey(childCtx, gr.ID, gr.Version), records)
 	if err != nil {
 		tracer.LogError(span, err)
-		return "error"
+		return nil, err
 	}
 
-	return reqId
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: key, Index: gr.Index},
+		&api.KVTxnOp{Verb: api.KVDeleteTree, Key: labelsKey},
+		trashOp,
+	}, nil
+}
+
+// commit applies ops in a single transaction. The events reporting the
+// change are written to the outbox in the same transaction, so they are
+// published if and only if the change is. So is the idempotency key ctx
+// carries, if any, see WithRequestId.
+func (cs *ConfigStore) commit(ctx context.Context, ops api.KVTxnOps, events ...*Event) error {
+	_, err := cs.commitIndex(ctx, "", ops, events...)
+	return err
 }
 
-func (cs *ConfigStore) FindRequestId(ctx context.Context, requestId string) bool {
-	span := tracer.StartSpanFromContext(ctx, "FindRequestId")
+// commitIndex is commit that also returns the index key was modified at by
+// the transaction, which is what its entity tag is made of.
+func (cs *ConfigStore) commitIndex(ctx context.Context, key string, ops api.KVTxnOps, events ...*Event) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "commit")
 	defer span.Finish()
 
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
 	kv := cs.cli.KV()
 
-	key, _, err := kv.Get(requestId, nil)
+	outbox, err := cs.outboxOps(childCtx, events)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
 
-	fmt.Println(key)
+	request, err := cs.requestOps(childCtx, events)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
 
-	if err != nil || key == nil {
+	all := append(append(append(api.KVTxnOps{}, request...), ops...), outbox...)
+	ok, resp, _, err := kv.Txn(all, nil)
+	if err != nil {
 		tracer.LogError(span, err)
-		return false
+		return 0, unavailable(err)
+	}
+
+	if !ok {
+		duplicate := false
+		for _, txnErr := range resp.Errors {
+			tracer.LogError(span, errors.New(txnErr.What))
+			if txnErr.OpIndex < len(all) && isRequestKey(all[txnErr.OpIndex].Key) {
+				duplicate = true
+			}
+		}
+		if duplicate {
+			return 0, ErrDuplicate
+		}
+		return 0, ErrConflict
+	}
+
+	for _, pair := range resp.Results {
+		if pair != nil && pair.Key == key {
+			return pair.ModifyIndex, nil
+		}
+	}
+	return 0, nil
+}
+
+// putWithIndex stores v under key only if the key has not been modified since
+// index was read, and returns the index it is modified at now.
+func (cs *ConfigStore) putWithIndex(ctx context.Context, key string, v interface{}, index uint64, events ...*Event) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "putWithIndex")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: data, Index: index},
 	}
 
-	return true
+	return cs.commitIndex(childCtx, key, ops, events...)
 }
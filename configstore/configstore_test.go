@@ -0,0 +1,255 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func createConfig(t *testing.T, store *ConfigStore, id, ver string) *Config {
+	t.Helper()
+
+	config, err := store.UpdateConfigVersion(context.Background(), &Config{ID: id, Version: ver, Entries: Entries{"k": "v"}})
+	if err != nil {
+		t.Fatalf("creating config %s %s: %v", id, ver, err)
+	}
+	return config
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDeleteConfigVersionsKeepsConfigsSharingPrefix(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	createConfig(t, store, "a", "v1")
+	createConfig(t, store, "a", "v2")
+	createConfig(t, store, "ab", "v1")
+	createConfig(t, store, "abc", "v1")
+
+	configs, err := store.FindConfVersions(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("found %d versions of a, want 2", len(configs))
+	}
+	for _, config := range configs {
+		if config.ID != "a" {
+			t.Errorf("versions of a include config %q", config.ID)
+		}
+	}
+
+	if err := store.DeleteConfigVersions(ctx, "a", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.FindConf(ctx, "a", "v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a v1 after delete: got %v, want ErrNotFound", err)
+	}
+	for _, id := range []string{"ab", "abc"} {
+		if _, err := store.FindConf(ctx, id, "v1"); err != nil {
+			t.Errorf("%s v1 after deleting a: %v", id, err)
+		}
+	}
+}
+
+func TestDeleteGroupVersionsKeepsGroupsSharingPrefix(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"g", "gh"} {
+		_, err := store.UpdateGroupVersion(ctx, &Group{ID: id, Version: "v1", Configs: []*GroupConfig{
+			{Labels: map[string]string{"env": "dev"}, Entries: Entries{"k": "v"}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DeleteGroupVersions(ctx, "g", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.FindGroup(ctx, "gh", "v1"); err != nil {
+		t.Errorf("gh v1 after deleting g: %v", err)
+	}
+	configs, err := store.FindLabels(ctx, "gh", "v1", map[string]string{"env": "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Errorf("found %d members of gh v1, want 1", len(configs))
+	}
+}
+
+func TestLabelsMatchExactly(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	group, err := store.CreateGroup(ctx, &Group{Version: "v1", Configs: []*GroupConfig{
+		{Labels: map[string]string{}, Entries: Entries{"k": "unlabelled"}},
+		{Labels: map[string]string{"env": "dev"}, Entries: Entries{"k": "dev"}},
+		{Labels: map[string]string{"env": "dev", "region": "eu"}, Entries: Entries{"k": "dev-eu"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		want   []string
+	}{
+		{nil, []string{"unlabelled"}},
+		{map[string]string{"env": "dev"}, []string{"dev"}},
+		{map[string]string{"region": "eu", "env": "dev"}, []string{"dev-eu"}},
+		{map[string]string{"region": "eu"}, nil},
+	}
+
+	for _, test := range tests {
+		configs, err := store.FindLabels(ctx, group.ID, "v1", test.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, config := range configs {
+			got = append(got, config.Entries["k"])
+		}
+		if !equalStrings(got, test.want) {
+			t.Errorf("members labelled %v: got %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
+
+func TestLegacyGroupMembersAreReadAsLabelsAndEntries(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	// Members used to be single maps, indexed by keys below the version.
+	kv := store.cli.KV()
+	legacy := []*api.KVPair{
+		{Key: "group/g/v1", Value: []byte(`{"id":"g","configs":[{"env":"dev","region":"eu"},{"env":"prod"}],"version":"v1"}`)},
+		{Key: "group/g/v1/env=dev&region=eu/1", Value: []byte(`{"env":"dev","region":"eu"}`)},
+		{Key: "group/g/v1/env=prod/2", Value: []byte(`{"env":"prod"}`)},
+	}
+	for _, pair := range legacy {
+		if _, err := kv.Put(pair, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, err := store.FindGroup(ctx, "g", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Configs) != 2 {
+		t.Fatalf("legacy group read with %d members, want 2", len(group.Configs))
+	}
+	dev := group.Configs[0]
+	want := map[string]string{"env": "dev", "region": "eu"}
+	if !sameLabels(dev.Labels, want) || !sameLabels(dev.Entries, want) {
+		t.Errorf("legacy member read as labels %v and entries %v, want %v for both", dev.Labels, dev.Entries, want)
+	}
+
+	prod, err := store.FindLabels(ctx, "g", "v1", map[string]string{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prod) != 1 || prod[0].Entries["env"] != "prod" {
+		t.Errorf("query of a legacy member returned %v", prod)
+	}
+
+	// Writing the group indexes its members like any other.
+	_, _, err = store.AddLabelsToGroup(ctx, []*GroupConfig{{Labels: map[string]string{"env": "test"}, Entries: Entries{"k": "v"}}}, "g", "v1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, labels := range []map[string]string{want, {"env": "prod"}, {"env": "test"}} {
+		configs, err := store.FindLabels(ctx, "g", "v1", labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(configs) != 1 {
+			t.Errorf("found %d members labelled %v after writing the group, want 1", len(configs), labels)
+		}
+	}
+}
+
+// members returns n group members numbered from offset. Every tenth one is
+// labelled tier=gold, the others each have a label set of their own.
+func members(n, offset int) []*GroupConfig {
+	configs := make([]*GroupConfig, n)
+	for i := range configs {
+		labels := map[string]string{"member": strconv.Itoa(offset + i)}
+		if (offset+i)%10 == 0 {
+			labels = map[string]string{"tier": "gold"}
+		}
+		configs[i] = &GroupConfig{Labels: labels, Entries: Entries{"n": strconv.Itoa(offset + i)}}
+	}
+	return configs
+}
+
+func TestGroupWritesWithMoreMembersThanATransactionHolds(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	n := txnLimit + 36
+	group, err := store.CreateGroup(ctx, &Group{Version: "v1", Configs: members(n, 0)})
+	if err != nil {
+		t.Fatalf("creating a group of %d members: %v", n, err)
+	}
+
+	gold, err := store.FindLabels(ctx, group.ID, "v1", map[string]string{"tier": "gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gold) != (n+9)/10 {
+		t.Errorf("found %d gold members, want %d", len(gold), (n+9)/10)
+	}
+
+	added, _, err := store.AddLabelsToGroup(ctx, members(txnLimit, n), group.ID, "v1", 0)
+	if err != nil {
+		t.Fatalf("adding %d members: %v", txnLimit, err)
+	}
+	if len(added) != n+txnLimit {
+		t.Errorf("group has %d members after adding, want %d", len(added), n+txnLimit)
+	}
+
+	one, err := store.FindLabels(ctx, group.ID, "v1", map[string]string{"member": strconv.Itoa(n + 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(one) != 1 || one[0].Entries["n"] != strconv.Itoa(n+1) {
+		t.Errorf("query of an added member returned %v", one)
+	}
+
+	removed, _, err := store.RemoveConfigsFromGroup(ctx, group.ID, "v1", map[string]string{"tier": "gold"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gold, err = store.FindLabels(ctx, group.ID, "v1", map[string]string{"tier": "gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) == 0 || len(gold) != 0 {
+		t.Errorf("removed %d gold members, %d left", len(removed), len(gold))
+	}
+
+	_, err = store.UpdateGroupVersion(ctx, &Group{ID: group.ID, Version: "v2", Configs: members(n, 0)})
+	if err != nil {
+		t.Fatalf("creating a version of %d members: %v", n, err)
+	}
+}
@@ -0,0 +1,25 @@
+package configstore
+
+import (
+	"testing"
+
+	"github.com/dekeract10/ARS-projekat/configstore/consultest"
+	"github.com/hashicorp/consul/api"
+)
+
+// newTestStore returns a store backed by a fresh consultest.Server.
+func newTestStore(t *testing.T) (*ConfigStore, *consultest.Server) {
+	t.Helper()
+
+	fake, address := consultest.NewServer()
+	t.Cleanup(fake.Close)
+
+	config := api.DefaultConfig()
+	config.Address = address
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ConfigStore{cli: client, retention: defaultRetention}, fake
+}
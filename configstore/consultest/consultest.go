@@ -0,0 +1,394 @@
+// Package consultest serves the parts of the Consul HTTP API the config
+// store uses from memory, so that the store can be tested without Consul.
+package consultest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// txnLimit is the number of operations Consul takes in a transaction.
+	txnLimit = 64
+	// maxWait is the longest Consul lets a blocking query wait.
+	maxWait = 10 * time.Minute
+)
+
+// Server serves the KV endpoints of Consul with blocking queries, and
+// transactions, which are applied atomically under a single index and capped
+// at txnLimit operations like Consul's. Its snapshots hold the stored pairs
+// as JSON rather than in Consul's format.
+type Server struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	index   uint64
+	pairs   map[string]*api.KVPair
+	// deleted holds the index each key was last deleted at, so that
+	// blocking queries on a prefix see deletes as changes.
+	deleted map[string]uint64
+
+	srv *httptest.Server
+}
+
+// NewServer starts a Server and returns it together with the address to
+// configure a Consul client with. Close stops it.
+func NewServer() (*Server, string) {
+	s := &Server{
+		index:   1,
+		pairs:   make(map[string]*api.KVPair),
+		deleted: make(map[string]uint64),
+	}
+	s.changed = sync.NewCond(&s.mu)
+	s.srv = httptest.NewServer(s)
+
+	return s, strings.TrimPrefix(s.srv.URL, "http://")
+}
+
+// Close stops the server.
+func (f *Server) Close() {
+	f.srv.Close()
+}
+
+// Keys returns the stored keys below prefix, sorted.
+func (f *Server) Keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for key := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case http.MethodGet:
+			f.get(w, r, key)
+		case http.MethodPut:
+			f.put(w, r, key)
+		case http.MethodDelete:
+			f.delete(w, r, key)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case r.URL.Path == "/v1/txn" && r.Method == http.MethodPut:
+		f.txn(w, r)
+	case r.URL.Path == "/v1/snapshot" && r.Method == http.MethodGet:
+		f.saveSnapshot(w)
+	case r.URL.Path == "/v1/snapshot" && r.Method == http.MethodPut:
+		f.restoreSnapshot(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *Server) reply(w http.ResponseWriter, status int, index uint64, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// match returns the pairs a read of key selects, and the index of the
+// selection.
+func (f *Server) match(key string, prefix bool) ([]*api.KVPair, uint64) {
+	var pairs []*api.KVPair
+	var index uint64
+	for k, pair := range f.pairs {
+		if k == key || (prefix && strings.HasPrefix(k, key)) {
+			pairs = append(pairs, pair)
+			if pair.ModifyIndex > index {
+				index = pair.ModifyIndex
+			}
+		}
+	}
+	for k, i := range f.deleted {
+		if (k == key || (prefix && strings.HasPrefix(k, key))) && i > index {
+			index = i
+		}
+	}
+	if index == 0 {
+		index = f.index
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return pairs, index
+}
+
+func (f *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	_, recurse := query["recurse"]
+	_, keys := query["keys"]
+	prefix := recurse || keys
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pairs, index := f.match(key, prefix)
+	if wait, _ := strconv.ParseUint(query.Get("index"), 10, 64); wait > 0 {
+		timeout, err := time.ParseDuration(query.Get("wait"))
+		if err != nil {
+			timeout = maxWait
+		}
+		expired := false
+		timer := time.AfterFunc(timeout, func() {
+			f.mu.Lock()
+			expired = true
+			f.mu.Unlock()
+			f.changed.Broadcast()
+		})
+		defer timer.Stop()
+
+		for index <= wait && !expired {
+			f.changed.Wait()
+			pairs, index = f.match(key, prefix)
+		}
+	}
+
+	if len(pairs) == 0 {
+		f.reply(w, http.StatusNotFound, index, nil)
+		return
+	}
+
+	if keys {
+		sep := query.Get("separator")
+		seen := make(map[string]bool)
+		list := []string{}
+		for _, pair := range pairs {
+			k := pair.Key
+			if i := strings.Index(k[len(key):], sep); sep != "" && i >= 0 {
+				k = k[:len(key)+i+len(sep)]
+			}
+			if !seen[k] {
+				seen[k] = true
+				list = append(list, k)
+			}
+		}
+		f.reply(w, http.StatusOK, index, list)
+		return
+	}
+	f.reply(w, http.StatusOK, index, pairs)
+}
+
+func (f *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cas := r.URL.Query().Get("cas"); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if !f.casOK(key, index) {
+			f.reply(w, http.StatusOK, f.index, false)
+			return
+		}
+	}
+
+	f.index++
+	f.set(key, value, f.index)
+	f.changed.Broadcast()
+	f.reply(w, http.StatusOK, f.index, true)
+}
+
+func (f *Server) delete(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cas := query.Get("cas"); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		pair, ok := f.pairs[key]
+		if !ok || pair.ModifyIndex != index {
+			f.reply(w, http.StatusOK, f.index, false)
+			return
+		}
+	}
+
+	f.index++
+	_, recurse := query["recurse"]
+	f.remove(key, recurse, f.index)
+	f.changed.Broadcast()
+	f.reply(w, http.StatusOK, f.index, true)
+}
+
+// casOK reports whether a check-and-set of key against index goes through:
+// index 0 requires the key not to exist, any other its modify index.
+func (f *Server) casOK(key string, index uint64) bool {
+	pair, ok := f.pairs[key]
+	if index == 0 {
+		return !ok
+	}
+	return ok && pair.ModifyIndex == index
+}
+
+func (f *Server) set(key string, value []byte, index uint64) *api.KVPair {
+	pair, ok := f.pairs[key]
+	if !ok {
+		pair = &api.KVPair{Key: key, CreateIndex: index}
+		f.pairs[key] = pair
+	}
+	pair.Value = value
+	pair.ModifyIndex = index
+	delete(f.deleted, key)
+	return pair
+}
+
+func (f *Server) remove(key string, prefix bool, index uint64) {
+	for k := range f.pairs {
+		if k == key || (prefix && strings.HasPrefix(k, key)) {
+			delete(f.pairs, k)
+			f.deleted[k] = index
+		}
+	}
+}
+
+// saveSnapshot answers with every stored pair.
+func (f *Server) saveSnapshot(w http.ResponseWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pairs, _ := f.match("", true)
+	f.reply(w, http.StatusOK, f.index, pairs)
+}
+
+// restoreSnapshot replaces the stored pairs with those of a snapshot
+// saveSnapshot answered with.
+func (f *Server) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	var pairs []*api.KVPair
+	if err := json.NewDecoder(r.Body).Decode(&pairs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.index++
+	f.remove("", true, f.index)
+	for _, pair := range pairs {
+		f.set(pair.Key, pair.Value, f.index)
+	}
+	f.changed.Broadcast()
+}
+
+// txn applies the operations of a transaction one after the other, each
+// seeing the effect of those before it, and discards them all if any fails.
+func (f *Server) txn(w http.ResponseWriter, r *http.Request) {
+	var ops api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(ops) > txnLimit {
+		http.Error(w, fmt.Sprintf("Transaction contains too many operations (%d > %d)", len(ops), txnLimit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	saved := make(map[string]api.KVPair, len(f.pairs))
+	for k, pair := range f.pairs {
+		saved[k] = *pair
+	}
+	savedDeleted := make(map[string]uint64, len(f.deleted))
+	for k, i := range f.deleted {
+		savedDeleted[k] = i
+	}
+
+	index := f.index + 1
+	resp := &api.TxnResponse{}
+	for i, op := range ops {
+		kv := op.KV
+		if kv == nil {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: "only KV operations are supported"})
+			continue
+		}
+
+		var what string
+		switch kv.Verb {
+		case api.KVSet:
+			resp.Results = append(resp.Results, &api.TxnResult{KV: f.set(kv.Key, kv.Value, index)})
+		case api.KVCAS:
+			if !f.casOK(kv.Key, kv.Index) {
+				what = "failed to set key " + kv.Key + ", index is stale"
+				break
+			}
+			resp.Results = append(resp.Results, &api.TxnResult{KV: f.set(kv.Key, kv.Value, index)})
+		case api.KVGet:
+			pair, ok := f.pairs[kv.Key]
+			if !ok {
+				what = "key " + kv.Key + " doesn't exist"
+				break
+			}
+			resp.Results = append(resp.Results, &api.TxnResult{KV: pair})
+		case api.KVDelete:
+			f.remove(kv.Key, false, index)
+		case api.KVDeleteTree:
+			f.remove(kv.Key, true, index)
+		case api.KVDeleteCAS:
+			pair, ok := f.pairs[kv.Key]
+			if !ok || pair.ModifyIndex != kv.Index {
+				what = "failed to delete key " + kv.Key + ", index is stale"
+				break
+			}
+			f.remove(kv.Key, false, index)
+		case api.KVCheckIndex:
+			pair, ok := f.pairs[kv.Key]
+			if !ok || pair.ModifyIndex != kv.Index {
+				what = "current modify index for key " + kv.Key + " doesn't match"
+			}
+		case api.KVCheckNotExists:
+			if _, ok := f.pairs[kv.Key]; ok {
+				what = "key " + kv.Key + " exists"
+			}
+		default:
+			what = "unknown verb " + string(kv.Verb)
+		}
+		if what != "" {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: what})
+		}
+	}
+
+	if len(resp.Errors) > 0 {
+		f.pairs = make(map[string]*api.KVPair, len(saved))
+		for k, pair := range saved {
+			pair := pair
+			f.pairs[k] = &pair
+		}
+		f.deleted = savedDeleted
+		f.reply(w, http.StatusConflict, f.index, &api.TxnResponse{Errors: resp.Errors})
+		return
+	}
+
+	f.index = index
+	f.changed.Broadcast()
+	f.reply(w, http.StatusOK, f.index, resp)
+}
@@ -0,0 +1,262 @@
+package configstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// separator joins the keys of nested entries into a single flat key.
+const separator = "."
+
+// Entries are the key/value pairs of a config. They are stored flat, but
+// may be given as nested objects, in which case nested keys are joined with
+// dots: {"db": {"host": "x"}} is stored as {"db.host": "x"}. Scalar values
+// other than strings are stored in their textual form.
+type Entries map[string]string
+
+func (e *Entries) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var tree map[string]interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return err
+	}
+	if tree == nil {
+		*e = nil
+		return nil
+	}
+
+	flat := make(Entries)
+	if err := flat.flatten("", tree); err != nil {
+		return err
+	}
+	*e = flat
+	return nil
+}
+
+func (e *Entries) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: entries must be a mapping", node.Line)
+	}
+
+	flat := make(Entries)
+	if err := flat.flattenYAML("", node); err != nil {
+		return err
+	}
+	*e = flat
+	return nil
+}
+
+func (e Entries) set(key, value string) error {
+	if _, ok := e[key]; ok {
+		return fmt.Errorf("duplicate entry %q", key)
+	}
+	e[key] = value
+	return nil
+}
+
+func (e Entries) flatten(prefix string, tree map[string]interface{}) error {
+	for k, v := range tree {
+		key := prefix + k
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := e.flatten(key+separator, v); err != nil {
+				return err
+			}
+			continue
+		case string:
+			if err := e.set(key, v); err != nil {
+				return err
+			}
+			continue
+		case json.Number, bool:
+			if err := e.set(key, fmt.Sprint(v)); err != nil {
+				return err
+			}
+			continue
+		}
+		return fmt.Errorf("entry %q must be a string, number, boolean or object", key)
+	}
+	return nil
+}
+
+func (e Entries) flattenYAML(prefix string, node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		key := prefix + k.Value
+
+		for v.Kind == yaml.AliasNode {
+			v = v.Alias
+		}
+
+		switch {
+		case v.Kind == yaml.MappingNode:
+			if err := e.flattenYAML(key+separator, v); err != nil {
+				return err
+			}
+		case v.Kind == yaml.ScalarNode && v.Tag != "!!null":
+			if err := e.set(key, v.Value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("line %d: entry %q must be a scalar or a mapping", v.Line, key)
+		}
+	}
+	return nil
+}
+
+// Nested reassembles flat entries into a tree by splitting their keys on
+// dots. It fails if a key holds both a value and nested entries.
+func (e Entries) Nested() (map[string]interface{}, error) {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tree := make(map[string]interface{})
+	for _, k := range keys {
+		parts := strings.Split(k, separator)
+
+		node := tree
+		for i, part := range parts[:len(parts)-1] {
+			child, ok := node[part]
+			if !ok {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+
+			next, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("entry %q holds both a value and nested entries", strings.Join(parts[:i+1], separator))
+			}
+			node = next
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := node[last]; ok {
+			return nil, fmt.Errorf("entry %q holds both a value and nested entries", k)
+		}
+		node[last] = e[k]
+	}
+	return tree, nil
+}
+
+// validate fails with ErrValidation if a key of e holds a value and also
+// has nested entries, as in {"db": "x", "db.host": "y"}, since such entries
+// can't be nested.
+func (e Entries) validate() error {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		parts := strings.Split(k, separator)
+		for i := 1; i < len(parts); i++ {
+			prefix := strings.Join(parts[:i], separator)
+			if _, ok := e[prefix]; ok {
+				return fmt.Errorf("%w: entry %q holds both a value and nested entries", ErrValidation, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMembers validates the entries of every group member.
+func validateMembers(configs []*GroupConfig) error {
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		if err := config.Entries.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to e as if its entries
+// were nested and returns the patched entries. The patch may be nested or
+// use flat keys, and a null removes an entry along with every entry nested
+// under it: {"db": null} removes db.host and db.port. A patch that is valid
+// JSON but can't be applied fails with ErrValidation.
+func (e Entries) MergePatch(patch []byte) (Entries, error) {
+	if !json.Valid(patch) {
+		return nil, errors.New("Merge patch is not valid JSON")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	tree, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: patched entries must be an object", ErrValidation)
+	}
+
+	patched := make(Entries, len(e))
+	for k, v := range e {
+		patched[k] = v
+	}
+	if err := patched.merge("", tree); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// merge applies the members of a merge patch nested under prefix. They are
+// applied in key order, so that the outcome doesn't depend on map order.
+func (e Entries) merge(prefix string, tree map[string]interface{}) error {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := prefix + k
+
+		switch v := tree[k].(type) {
+		case nil:
+			e.remove(key)
+		case map[string]interface{}:
+			// An object replaces a value but is merged into nested entries.
+			delete(e, key)
+			if err := e.merge(key+separator, v); err != nil {
+				return err
+			}
+		case string:
+			e.remove(key)
+			e[key] = v
+		case json.Number, bool:
+			e.remove(key)
+			e[key] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%w: entry %q must be a string, number, boolean, object or null", ErrValidation, key)
+		}
+	}
+	return nil
+}
+
+// remove deletes the entry under key and every entry nested under it.
+func (e Entries) remove(key string) {
+	delete(e, key)
+	for k := range e {
+		if strings.HasPrefix(k, key+separator) {
+			delete(e, k)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatchRemovesNestedEntries(t *testing.T) {
+	entries := Entries{"db.host": "x", "db.port": "5432", "dbname": "app", "log": "info"}
+
+	tests := []struct {
+		patch string
+		want  Entries
+	}{
+		{`{"db": null}`, Entries{"dbname": "app", "log": "info"}},
+		{`{"db.port": null}`, Entries{"db.host": "x", "dbname": "app", "log": "info"}},
+		{`{"db": {"port": 6432}}`, Entries{"db.host": "x", "db.port": "6432", "dbname": "app", "log": "info"}},
+		{`{"db": "off"}`, Entries{"db": "off", "dbname": "app", "log": "info"}},
+		{`{"log": {"level": "debug"}}`, Entries{"db.host": "x", "db.port": "5432", "dbname": "app", "log.level": "debug"}},
+	}
+
+	for _, test := range tests {
+		patched, err := entries.MergePatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: %v", test.patch, err)
+			continue
+		}
+		if !reflect.DeepEqual(patched, test.want) {
+			t.Errorf("%s: patched to %v, want %v", test.patch, patched, test.want)
+		}
+	}
+
+	if len(entries) != 4 {
+		t.Errorf("patching changed the entries patched to %v", entries)
+	}
+}
+
+func TestConflictingEntriesAreNotWritten(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	_, err := store.CreateConfig(ctx, &Config{Version: "v1", Entries: Entries{"db": "x", "db.host": "y"}})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("created config with conflicting entries: %v", err)
+	}
+
+	_, err = store.CreateGroup(ctx, &Group{Version: "v1", Configs: []*GroupConfig{
+		{Labels: map[string]string{"l": "1"}, Entries: Entries{"a.b.c": "x", "a.b": "y"}},
+	}})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("created group with conflicting entries: %v", err)
+	}
+
+	if keys := fake.Keys(""); len(keys) != 0 {
+		t.Errorf("rejected writes left %v", keys)
+	}
+}
@@ -0,0 +1,79 @@
+package configstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound = errors.New("That item does not exist!")
+	ErrConflict = errors.New("Item was modified concurrently, try again")
+	ErrExists   = errors.New("Item already exists")
+
+	// ErrDuplicate is returned for a write whose idempotency key was already
+	// used by another request.
+	ErrDuplicate = errors.New("Request has been already sent")
+
+	ErrPreconditionFailed = errors.New("Item has changed since it was read")
+
+	ErrImmutable    = errors.New("Only draft versions can be modified")
+	ErrInvalidState = errors.New("Invalid version state transition")
+	ErrPublished    = errors.New("Some versions are published, use force to delete them")
+
+	ErrInvalidOp = errors.New("Invalid batch operation")
+	ErrAborted   = errors.New("Batch aborted because another operation failed")
+
+	// ErrValidation is wrapped by errors about input the store won't keep.
+	ErrValidation = errors.New("Invalid input")
+	// ErrUnavailable is wrapped by errors about Consul failing or being out
+	// of reach. Unlike the others it says nothing about the item itself,
+	// and the call may succeed when retried.
+	ErrUnavailable = errors.New("Store is unavailable, try again later")
+)
+
+// codes are the stable, machine-readable names of the errors above as they
+// are reported to clients. Codes must never change once published.
+var codes = []struct {
+	err  error
+	code string
+}{
+	{ErrNotFound, "not_found"},
+	{ErrConflict, "conflict"},
+	{ErrExists, "already_exists"},
+	{ErrDuplicate, "duplicate_request"},
+	{ErrPreconditionFailed, "precondition_failed"},
+	{ErrImmutable, "immutable"},
+	{ErrInvalidState, "invalid_state"},
+	{ErrPublished, "published"},
+	{ErrInvalidOp, "invalid_operation"},
+	{ErrAborted, "aborted"},
+	{ErrValidation, "validation_failed"},
+	{ErrUnavailable, "unavailable"},
+}
+
+// Code returns the code of the store error err is or wraps, or "" for
+// errors the store doesn't classify.
+func Code(err error) string {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// FromCode returns the store error with the given code, or nil if there is
+// none.
+func FromCode(code string) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// unavailable wraps an error returned by Consul in ErrUnavailable.
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
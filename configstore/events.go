@@ -0,0 +1,65 @@
+package configstore
+
+import (
+	"time"
+)
+
+// Operations reported by events.
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+	EventDelete = "delete"
+
+	// EventReset is the operation of the event a stream sends in place of
+	// events it no longer has. Its receiver has missed changes up to Seq
+	// and should read the state afresh.
+	EventReset = "reset"
+)
+
+// Event describes a committed change to a config or group version. Labels
+// holds the label sets of the members of a group. Seq numbers the events in
+// the order they were committed and keeps counting across restarts, though
+// not without gaps.
+type Event struct {
+	Seq       uint64              `json:"seq"`
+	Resource  string              `json:"resource"`
+	ID        string              `json:"id"`
+	Version   string              `json:"version"`
+	Operation string              `json:"operation"`
+	Labels    []map[string]string `json:"labels,omitempty"`
+	Time      time.Time           `json:"time"`
+}
+
+// listener is a function registered with OnEvent. Its name keys the cursor
+// that records how far it has taken the outbox.
+type listener struct {
+	name string
+	take func(*Event) error
+}
+
+// OnEvent registers l under name to be called with every change committed
+// through the store. Listeners are called synchronously by the outbox
+// dispatcher, and an error tells it that the event hasn't been taken over
+// and has to be passed on again. An event may therefore reach a listener
+// more than once. Every listener keeps its own place in the outbox, so one
+// that fails holds back neither the others nor their events.
+func (cs *ConfigStore) OnEvent(name string, l func(*Event) error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.listeners = append(cs.listeners, listener{name: name, take: l})
+}
+
+func configEvent(op string, config *Config) *Event {
+	return &Event{Resource: ResourceConfig, ID: config.ID, Version: config.Version, Operation: op}
+}
+
+// groupEvent reports a change to group. The labels of members that were
+// removed are included so that watchers of those labels see the change too.
+func groupEvent(op string, group *Group, removed ...*GroupConfig) *Event {
+	event := &Event{Resource: ResourceGroup, ID: group.ID, Version: group.Version, Operation: op}
+	for _, config := range append(group.Configs, removed...) {
+		event.Labels = append(event.Labels, config.Labels)
+	}
+	return event
+}
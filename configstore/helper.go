@@ -2,10 +2,14 @@ package configstore
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	tracer "github.com/dekeract10/ARS-projekat/tracer"
-	"sort"
+	"net/url"
+	"strings"
 
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
 	"github.com/google/uuid"
 )
 
@@ -14,13 +18,34 @@ const (
 	configId   = "config/%s"
 	config     = "config/%s/%s"
 
-	allGroups      = "group"
-	groupId        = "group/%s"
-	groupVer       = "group/%s/%s"
-	group          = "group/%s/%s/%s"
-	groupWithLabel = "group/%s/%s/%s/%s"
+	allGroups   = "group"
+	groupId     = "group/%s"
+	groupVer    = "group/%s/%s"
+	groupLabels = "group/%s/%s/labels"
+
+	allRequests = "request/"
+	requestId   = "request/%s"
+
+	restoredFrom = "meta/restored-from"
+	allCursors   = "meta/outbox-dispatched/"
+	outboxCursor = "meta/outbox-dispatched/%s"
+	outboxPruned = "meta/outbox-pruned"
+	leaderLock   = "meta/dispatcher-lock"
+
+	allOutbox = "outbox/"
+	outbox    = "outbox/%s"
 
-	requestId = "request/%s"
+	allWebhooks     = "webhook/"
+	webhook         = "webhook/%s"
+	webhookDelivery = "webhook-delivery/%s/%s"
+	webhookDead     = "webhook-dead/%s/%s"
+	allPending      = "webhook-pending/"
+	webhookPending  = "webhook-pending/%s/%s"
+
+	allTrash    = "trash/"
+	trashConfig = "trash/config/%s/%s/"
+	trashGroup  = "trash/group/%s/%s/"
+	trashEntry  = "%020d"
 )
 
 func generateConfigKey(ctx context.Context, ver string) (string, string) {
@@ -67,29 +92,185 @@ func constructGroupIdKey(ctx context.Context, id string) string {
 	return fmt.Sprintf(groupId, id)
 }
 
-func constructGroupLabel(ctx context.Context, id, ver, index string, config map[string]string) string {
-	span := tracer.StartSpanFromContext(ctx, "constructGroupLabel")
+func constructGroupLabelsKey(ctx context.Context, id string, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructGroupLabelsKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(groupVer, id, ver) + "/"
+}
+
+func constructGroupIndexKey(ctx context.Context, id, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructGroupIndexKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(groupLabels, id, ver)
+}
+
+// encodeLabels turns a label set into a key of the label index. Labels are
+// sorted and escaped as a query string and then hashed, so values containing
+// '/', '&' or '=' and arbitrarily large label sets map to a fixed-size key.
+func encodeLabels(labels map[string]string) string {
+	values := url.Values{}
+	for k, v := range labels {
+		values.Set(k, v)
+	}
+
+	sum := sha256.Sum256([]byte(values.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+func constructOutboxKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructOutboxKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(outbox, id)
+}
+
+func constructCursorKey(ctx context.Context, name string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructCursorKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(outboxCursor, name)
+}
+
+func constructTrashConfigKey(ctx context.Context, id string, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructTrashConfigKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(trashConfig, id, ver)
+}
+
+func constructTrashGroupKey(ctx context.Context, id string, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructTrashGroupKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(trashGroup, id, ver)
+}
+
+func constructWebhookKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructWebhookKey")
 	defer span.Finish()
 
-	keys := make([]string, 0, len(config))
-	for k := range config {
-		keys = append(keys, k)
+	return fmt.Sprintf(webhook, id)
+}
+
+// constructDeliveryKey orders the deliveries of a webhook by creation time.
+func constructDeliveryKey(ctx context.Context, d *Delivery) string {
+	span := tracer.StartSpanFromContext(ctx, "constructDeliveryKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(webhookDelivery, d.Webhook, fmt.Sprintf("%020d-%s", d.CreatedAt.UnixNano(), d.ID))
+}
+
+func constructPendingKey(ctx context.Context, hook, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructPendingKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(webhookPending, hook, id)
+}
+
+func constructDeadLetterKey(ctx context.Context, hook, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructDeadLetterKey")
+	defer span.Finish()
+
+	return fmt.Sprintf(webhookDead, hook, id)
+}
+
+// isGroupKey reports whether key holds a group version rather than its
+// label index.
+func isGroupKey(key string) bool {
+	return strings.Count(key, "/") == strings.Count(groupVer, "/")
+}
+
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
 	}
+	return true
+}
+
+// initialState validates the state a new version is created in. Versions
+// default to drafts but may also be published right away.
+func initialState(state string) (string, error) {
+	switch state {
+	case "":
+		return Draft, nil
+	case Draft, Published:
+		return state, nil
+	}
+	return "", ErrInvalidState
+}
 
-	sort.Strings(keys)
+// storedState returns the state of a stored version. Records written before
+// versions had a lifecycle carry no state and are treated as drafts.
+func storedState(state string) string {
+	if state == "" {
+		return Draft
+	}
+	return state
+}
+
+// unmarshalGroup reads a stored group version into group. Members written
+// before they had labels and entries of their own are a single map, which
+// served as both and is read as both.
+func unmarshalGroup(data []byte, group *Group) error {
+	err := json.Unmarshal(data, group)
+	if err != nil {
+		return err
+	}
+
+	var stored struct {
+		Configs []map[string]json.RawMessage `json:"configs"`
+	}
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	for i, member := range stored.Configs {
+		_, labels := member["labels"]
+		_, entries := member["entries"]
+		if labels || entries {
+			continue
+		}
+
+		legacy := make(map[string]string, len(member))
+		for k, v := range member {
+			var value string
+			if err := json.Unmarshal(v, &value); err != nil {
+				return fmt.Errorf("member %d: %v", i, err)
+			}
+			legacy[k] = value
+		}
+
+		config := &GroupConfig{Labels: legacy, Entries: make(Entries, len(legacy))}
+		for k, v := range legacy {
+			config.Entries[k] = v
+		}
+		group.Configs[i] = config
+	}
+	return nil
+}
 
-	var kvpairs string
-	for k := range keys {
-		kvpairs = kvpairs + fmt.Sprintf("%s=%s", keys[k], config[keys[k]]+"&")
+func canTransition(from, to string) bool {
+	switch from {
+	case Draft:
+		return to == Published
+	case Published:
+		return to == Deprecated
 	}
-	kvpairs = kvpairs[:len(kvpairs)-1]
-	return fmt.Sprintf(groupWithLabel, id, ver, kvpairs, index)
+	return false
 }
 
-func generateRequestId(ctx context.Context) string {
-	span := tracer.StartSpanFromContext(ctx, "generateRequestId")
+func constructRequestIdKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructRequestIdKey")
 	defer span.Finish()
 
-	rid := uuid.New().String()
-	return rid
+	return fmt.Sprintf(requestId, id)
 }
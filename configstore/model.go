@@ -1,13 +1,94 @@
 package configstore
 
+import "time"
+
+// Lifecycle states of a config or group version. Versions are created as
+// drafts, and only drafts can be modified. Once published a version is
+// immutable and can only be deprecated.
+const (
+	Draft      = "draft"
+	Published  = "published"
+	Deprecated = "deprecated"
+)
+
 type Group struct {
-	ID      string              `json:"id"`
-	Configs []map[string]string `json:"configs"`
-	Version string              `json:"version"`
+	ID      string         `json:"id" yaml:"id" toml:"id"`
+	Configs []*GroupConfig `json:"configs" yaml:"configs" toml:"configs"`
+	Version string         `json:"version" yaml:"version" toml:"version"`
+	State   string         `json:"state" yaml:"state" toml:"state"`
+	Index   uint64         `json:"-" yaml:"-" toml:"-"`
+}
+
+type GroupConfig struct {
+	Labels  map[string]string `json:"labels" yaml:"labels" toml:"labels"`
+	Entries Entries           `json:"entries" yaml:"entries" toml:"entries"`
+}
+
+// HasLabels reports whether config is labelled with exactly labels, no more
+// and no fewer, which is how the store selects group members.
+func (config *GroupConfig) HasLabels(labels map[string]string) bool {
+	return sameLabels(config.Labels, labels)
 }
 
 type Config struct {
-	ID      string            `json:"id"`
-	Version string            `json:"version"`
-	Entries map[string]string `json:"entries"`
+	ID      string  `json:"id" yaml:"id" toml:"id"`
+	Version string  `json:"version" yaml:"version" toml:"version"`
+	Entries Entries `json:"entries" yaml:"entries" toml:"entries"`
+	State   string  `json:"state" yaml:"state" toml:"state"`
+	Index   uint64  `json:"-" yaml:"-" toml:"-"`
+}
+
+// Record is a raw key/value pair of the store.
+type Record struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// TrashRecord holds every key removed by a single delete so that it can be
+// restored until the record expires.
+type TrashRecord struct {
+	DeletedAt time.Time `json:"deletedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Records   []*Record `json:"records"`
+}
+
+// RestoreMarker records which snapshot the store was last restored from.
+type RestoreMarker struct {
+	Snapshot   string    `json:"snapshot"`
+	RestoredAt time.Time `json:"restoredAt"`
+}
+
+// Webhook receives the events of a config, of a group or, with neither set,
+// of the whole store. The secret signs the payloads.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Config    string    `json:"config,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Delivery states.
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Failed    = "failed"
+	Dead      = "dead"
+)
+
+// Delivery records an attempt to send an event to a webhook. Deliveries
+// that ran out of retries are kept as dead letters. NextAttempt is when a
+// failed delivery is due to be sent again.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       *Event    `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
 }
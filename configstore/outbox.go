@@ -0,0 +1,354 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/hashicorp/consul/api"
+)
+
+// leaderTTL is how long the dispatcher lock outlives an instance that
+// stopped renewing it.
+const leaderTTL = "15s"
+
+// outboxRetention is how long dispatched records stay in the outbox. It is
+// only changed by tests.
+var outboxRetention = time.Minute
+
+// outboxOps returns the operation that writes events to the outbox. The
+// events of a transaction share a single record, and are only numbered when
+// they are read, after the index the record was created at. Commits
+// therefore don't have to agree on a sequence beforehand.
+func (cs *ConfigStore) outboxOps(ctx context.Context, events []*Event) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "outboxOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+	if len(events) > txnLimit {
+		return nil, fmt.Errorf("%w: a transaction reports at most %d changes", ErrValidation, txnLimit)
+	}
+
+	now := time.Now().UTC()
+	for _, event := range events {
+		event.Time = now
+	}
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVSet, Key: constructOutboxKey(childCtx, uuid.New().String()), Value: data},
+	}, nil
+}
+
+// outboxEvents returns the events held by outbox records in the order they
+// were committed. Every transaction commits at a higher index than the one
+// before it and carries at most txnLimit events, so the index of its record
+// times txnLimit plus the position of an event numbers the events in order.
+func outboxEvents(pairs api.KVPairs) ([]*Event, error) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].CreateIndex < pairs[j].CreateIndex
+	})
+
+	var all []*Event
+	for _, pair := range pairs {
+		var events []*Event
+		err := json.Unmarshal(pair.Value, &events)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, event := range events {
+			event.Seq = pair.CreateIndex*txnLimit + uint64(i)
+		}
+		all = append(all, events...)
+	}
+	return all, nil
+}
+
+// DispatchOutbox passes the events in the outbox that haven't been
+// dispatched yet to the listeners, in the order they were committed. It
+// blocks until the outbox changes past index or wait has passed, and returns
+// the index to wait on next.
+//
+// The sequence number of the last event a listener accepted is kept in the
+// store, under its name. When a listener fails, it isn't passed any later
+// event, the error is returned and the event is dispatched to it again by
+// the next call; passing index 0 makes that happen right away. The other
+// listeners carry on meanwhile. Events are thus delivered at least once,
+// also when the dispatcher crashes or moves to another instance. Dispatched
+// records are kept for outboxRetention, so that every instance can tail
+// them, and removed after, once every listener has taken their events.
+// Only the instance that leads, see Lead, should dispatch.
+func (cs *ConfigStore) DispatchOutbox(ctx context.Context, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "DispatchOutbox")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	// Records expire without the outbox changing.
+	if wait > outboxRetention {
+		wait = outboxRetention
+	}
+
+	kv := cs.cli.KV()
+	pairs, meta, err := kv.List(allOutbox, queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return index, unavailable(err)
+	}
+
+	cursors, _, err := kv.List(allCursors, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return index, unavailable(err)
+	}
+	byName := make(map[string]*api.KVPair, len(cursors))
+	for _, cursor := range cursors {
+		byName[strings.TrimPrefix(cursor.Key, allCursors)] = cursor
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].CreateIndex < pairs[j].CreateIndex
+	})
+
+	var all []*Event
+	var records api.KVPairs
+	var batches [][]*Event
+	var expired api.KVPairs
+	for _, pair := range pairs {
+		events, err := outboxEvents(api.KVPairs{pair})
+		if err != nil {
+			// A record that can't be read would block the outbox for good.
+			tracer.LogError(span, err)
+			expired = append(expired, pair)
+			continue
+		}
+		if len(events) == 0 {
+			expired = append(expired, pair)
+			continue
+		}
+		all = append(all, events...)
+		records = append(records, pair)
+		batches = append(batches, events)
+	}
+
+	cs.mu.Lock()
+	listeners := cs.listeners
+	cs.mu.Unlock()
+
+	// Without listeners, there is no one left to take the events.
+	lowest := ^uint64(0)
+	var failed error
+	var ops api.KVTxnOps
+	for _, l := range listeners {
+		// A cursor that was never written is read with index 0, which the
+		// check-and-set below takes to mean the key must not exist yet.
+		var dispatched, cursorIndex uint64
+		if cursor := byName[l.name]; cursor != nil {
+			dispatched, err = strconv.ParseUint(string(cursor.Value), 10, 64)
+			if err != nil {
+				tracer.LogError(span, err)
+				return index, err
+			}
+			cursorIndex = cursor.ModifyIndex
+		}
+
+		last := dispatched
+		for _, event := range all {
+			if event.Seq <= last {
+				continue
+			}
+			if err := l.take(event); err != nil {
+				tracer.LogError(span, err)
+				if failed == nil {
+					failed = fmt.Errorf("%s: %w", l.name, err)
+				}
+				break
+			}
+			last = event.Seq
+		}
+
+		if last != dispatched {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: constructCursorKey(childCtx, l.name), Value: []byte(strconv.FormatUint(last, 10)), Index: cursorIndex})
+		}
+		if last < lowest {
+			lowest = last
+		}
+	}
+
+	if len(ops) > 0 {
+		ok, _, _, err := kv.Txn(ops, nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return index, unavailable(err)
+		}
+		if !ok {
+			return index, ErrConflict
+		}
+	}
+
+	// Records are pruned in order, and only once every listener is past
+	// them, so that a listener that fails keeps the events it hasn't taken.
+	var pruned uint64
+	for i, pair := range records {
+		events := batches[i]
+		seq := events[len(events)-1].Seq
+		if seq > lowest || time.Since(events[0].Time) <= outboxRetention {
+			break
+		}
+		expired = append(expired, pair)
+		pruned = seq
+	}
+
+	err = cs.prune(ctx, expired, pruned)
+	if err != nil {
+		tracer.LogError(span, err)
+		return index, err
+	}
+	if failed != nil {
+		return index, failed
+	}
+
+	return meta.LastIndex, nil
+}
+
+// prune removes expired outbox records and records seq, the number of the
+// latest event they held, in the same transaction. TailOutbox reads it to
+// tell whether a tail has fallen behind.
+func (cs *ConfigStore) prune(ctx context.Context, expired api.KVPairs, seq uint64) error {
+	span := tracer.StartSpanFromContext(ctx, "prune")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	for start := 0; start < len(expired); start += txnLimit - 1 {
+		end := start + txnLimit - 1
+		if end > len(expired) {
+			end = len(expired)
+		}
+
+		var ops api.KVTxnOps
+		for _, pair := range expired[start:end] {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex})
+		}
+		if seq != 0 {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: outboxPruned, Value: []byte(strconv.FormatUint(seq, 10))})
+		}
+
+		ok, _, _, err := kv.Txn(ops, nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return unavailable(err)
+		}
+		if !ok {
+			return ErrConflict
+		}
+	}
+
+	return nil
+}
+
+// TailOutbox returns the events in the outbox numbered after seq, once the
+// outbox changes past index or wait has passed, together with the number of
+// the latest event pruned from the outbox and the index to wait on next.
+// Every instance can tail the outbox, whichever of them dispatches it. A
+// tail that falls behind by more than outboxRetention misses events, which
+// it tells by a pruned number higher than its own.
+func (cs *ConfigStore) TailOutbox(ctx context.Context, seq, index uint64, wait time.Duration) ([]*Event, uint64, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "TailOutbox")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	pairs, meta, err := kv.List(allOutbox, queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, index, unavailable(err)
+	}
+
+	// Read after the records, the number can only be too high, never too
+	// low.
+	var pruned uint64
+	pair, _, err := kv.Get(outboxPruned, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, index, unavailable(err)
+	}
+	if pair != nil {
+		pruned, err = strconv.ParseUint(string(pair.Value), 10, 64)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, index, err
+		}
+	}
+
+	all, err := outboxEvents(pairs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, index, err
+	}
+
+	events := []*Event{}
+	for _, event := range all {
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, pruned, meta.LastIndex, nil
+}
+
+// Lead blocks until this instance holds the dispatcher lock, or ctx is done.
+// It returns a channel that is closed if the lock is lost, e.g. because the
+// backend couldn't be reached for a while, and a function that releases the
+// lock, which is to be called in either case.
+// Instances that don't lead leave the outbox and webhook deliveries to the
+// one that does.
+func (cs *ConfigStore) Lead(ctx context.Context) (<-chan struct{}, func() error, error) {
+	span := tracer.StartSpanFromContext(ctx, "Lead")
+	defer span.Finish()
+
+	lock, err := cs.cli.LockOpts(&api.LockOptions{
+		Key:         leaderLock,
+		SessionName: "configstore-dispatcher",
+		SessionTTL:  leaderTTL,
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	lost, err := lock.Lock(stop)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, unavailable(err)
+	}
+	if lost == nil {
+		return nil, nil, ctx.Err()
+	}
+
+	return lost, lock.Unlock, nil
+}
@@ -0,0 +1,196 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCommitsNumberEventsInOrder(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	const writers, writes = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writes)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				_, err := store.CreateConfig(ctx, &Config{Version: "v1", Entries: Entries{"k": "v"}})
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent commit: %v", err)
+		}
+	}
+
+	var events []*Event
+	store.OnEvent("test", func(event *Event) error {
+		events = append(events, event)
+		return nil
+	})
+	if _, err := store.DispatchOutbox(ctx, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != writers*writes {
+		t.Fatalf("dispatched %d events, want %d", len(events), writers*writes)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Fatalf("event %d has seq %d after %d", i, events[i].Seq, events[i-1].Seq)
+		}
+	}
+	if _, err := store.DispatchOutbox(ctx, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != writers*writes {
+		t.Errorf("dispatching again passed on %d events", len(events)-writers*writes)
+	}
+	if keys := fake.Keys(allOutbox); len(keys) != writers*writes {
+		t.Errorf("outbox holds %d records right after dispatching, want them kept", len(keys))
+	}
+}
+
+func TestDispatchKeepsEventsAListenerFailedToTake(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	createConfig(t, store, "a", "v1")
+	createConfig(t, store, "b", "v1")
+
+	var taken, offered []string
+	failing := true
+	store.OnEvent("test", func(event *Event) error {
+		offered = append(offered, event.ID)
+		if failing && event.ID == "b" {
+			return errors.New("sink unavailable")
+		}
+		taken = append(taken, event.ID)
+		return nil
+	})
+
+	if _, err := store.DispatchOutbox(ctx, 0, time.Second); err == nil {
+		t.Fatal("dispatch succeeded although the listener failed")
+	}
+
+	// Another instance takes over dispatching where the first one left.
+	failing = false
+	other := &ConfigStore{cli: store.cli, retention: store.retention, listeners: store.listeners}
+	for i := 0; i < 2; i++ {
+		if _, err := other.DispatchOutbox(ctx, 0, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"a", "b", "b"}; !equalStrings(offered, want) {
+		t.Errorf("offered %v, want %v", offered, want)
+	}
+	if want := []string{"a", "b"}; !equalStrings(taken, want) {
+		t.Errorf("taken %v, want %v", taken, want)
+	}
+}
+
+func TestFailingListenerDoesNotHoldBackOthers(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	defer func(retention time.Duration) { outboxRetention = retention }(outboxRetention)
+	outboxRetention = 0
+
+	var delivered, published []string
+	down := true
+	store.OnEvent("bus", func(event *Event) error {
+		if down {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, event.ID)
+		return nil
+	})
+	store.OnEvent("webhooks", func(event *Event) error {
+		delivered = append(delivered, event.ID)
+		return nil
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		createConfig(t, store, id, "v1")
+		if _, err := store.DispatchOutbox(ctx, 0, 0); err == nil {
+			t.Fatal("dispatch succeeded although a listener failed")
+		}
+	}
+
+	if want := []string{"a", "b", "c"}; !equalStrings(delivered, want) {
+		t.Errorf("delivered %v while the bus was down, want %v", delivered, want)
+	}
+	if keys := fake.Keys(allOutbox); len(keys) != 3 {
+		t.Errorf("outbox holds %d records the bus hasn't taken, want 3", len(keys))
+	}
+
+	down = false
+	if _, err := store.DispatchOutbox(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !equalStrings(published, want) {
+		t.Errorf("published %v once the bus was back, want %v", published, want)
+	}
+	if len(delivered) != 3 {
+		t.Errorf("delivered %d events, want each once", len(delivered))
+	}
+	if keys := fake.Keys(allOutbox); len(keys) != 0 {
+		t.Errorf("outbox holds %d records every listener has taken", len(keys))
+	}
+}
+
+func TestTailSeesEventsUntilTheyExpire(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	createConfig(t, store, "a", "v1")
+	createConfig(t, store, "b", "v1")
+
+	events, pruned, _, err := store.TailOutbox(ctx, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || pruned != 0 {
+		t.Fatalf("tail returned %d events and pruned %d, want 2 and 0", len(events), pruned)
+	}
+
+	later, _, _, err := store.TailOutbox(ctx, events[0].Seq, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(later) != 1 || later[0].ID != "b" {
+		t.Errorf("tail after the first event returned %v", later)
+	}
+
+	defer func(retention time.Duration) { outboxRetention = retention }(outboxRetention)
+	outboxRetention = 0
+	store.OnEvent("test", func(*Event) error { return nil })
+
+	if _, err := store.DispatchOutbox(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.Keys(allOutbox); len(keys) != 0 {
+		t.Errorf("outbox holds %d records after they expired", len(keys))
+	}
+
+	// A tail that hadn't read b yet learns that it missed it.
+	events, pruned, _, err = store.TailOutbox(ctx, events[0].Seq, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || pruned != later[0].Seq {
+		t.Errorf("tail after pruning returned %d events and pruned %d, want 0 and %d", len(events), pruned, later[0].Seq)
+	}
+}
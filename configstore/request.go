@@ -0,0 +1,155 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Request records what a request sent with an idempotency key changed, so
+// that the request can be answered again when it is retried.
+type Request struct {
+	Changes []*Event `json:"changes"`
+	// Partial is set when the changes were made by several transactions, so
+	// that some may be missing.
+	Partial bool `json:"partial,omitempty"`
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a context under which a write reserves the
+// idempotency key id, in the same transaction that makes the change, and
+// records the change under it. A write under a key that is already reserved
+// changes nothing. It fails with ErrDuplicate, unless it fails for another
+// reason first, e.g. because the version it creates already exists; either
+// way FindRequest tells what the write that reserved the key changed.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+func requestIdFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// requestOps returns the operation that reserves the idempotency key ctx
+// carries, if any, and records changes under it.
+func (cs *ConfigStore) requestOps(ctx context.Context, changes []*Event) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "requestOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	id := requestIdFrom(ctx)
+	if id == "" {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(newRequest(changes, false))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// Index 0 makes the check-and-set fail if the key is already reserved.
+	return api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructRequestIdKey(childCtx, id), Value: data, Index: 0},
+	}, nil
+}
+
+// newRequest returns the record of changes. Only what identifies a change
+// is kept, the rest may be large.
+func newRequest(changes []*Event, partial bool) *Request {
+	request := &Request{Changes: []*Event{}, Partial: partial}
+	for _, change := range changes {
+		request.Changes = append(request.Changes, &Event{Resource: change.Resource, ID: change.ID, Version: change.Version, Operation: change.Operation})
+	}
+	return request
+}
+
+// ReserveRequest reserves the idempotency key ctx carries for changes made
+// outside of a store transaction, see WithRequestId. It fails with
+// ErrDuplicate if the key is already reserved.
+func (cs *ConfigStore) ReserveRequest(ctx context.Context, changes ...*Event) error {
+	span := tracer.StartSpanFromContext(ctx, "ReserveRequest")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ops, err := cs.requestOps(childCtx, changes)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	// The key is reserved on its own, the changes are made by the caller.
+	return cs.commit(WithRequestId(childCtx, ""), ops)
+}
+
+// recordRequest replaces what is recorded under the idempotency key ctx
+// carries, once changes that were reserved ahead have been made.
+func (cs *ConfigStore) recordRequest(ctx context.Context, request *Request) error {
+	span := tracer.StartSpanFromContext(ctx, "recordRequest")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	kv := cs.cli.KV()
+	_, err = kv.Put(&api.KVPair{Key: constructRequestIdKey(childCtx, requestIdFrom(ctx)), Value: data}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return unavailable(err)
+	}
+	return nil
+}
+
+// FindRequest returns what the request that reserved the idempotency key id
+// changed, or nil if no request did.
+func (cs *ConfigStore) FindRequest(ctx context.Context, id string) (*Request, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindRequest")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if id == "" {
+		return nil, nil
+	}
+
+	kv := cs.cli.KV()
+	pair, _, err := kv.Get(constructRequestIdKey(childCtx, id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	// Keys reserved before changes were recorded hold no value.
+	request := &Request{}
+	if len(pair.Value) == 0 {
+		return request, nil
+	}
+
+	err = json.Unmarshal(pair.Value, request)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// isRequestKey reports whether key holds an idempotency key reservation.
+func isRequestKey(key string) bool {
+	return strings.HasPrefix(key, allRequests)
+}
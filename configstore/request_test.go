@@ -0,0 +1,83 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestRetriedWriteIsMadeOnce(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := WithRequestId(context.Background(), "retry")
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	configs := make(chan *Config, attempts)
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, err := store.CreateConfig(ctx, &Config{Version: "v1", Entries: Entries{"k": "v"}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			configs <- config
+		}()
+	}
+	wg.Wait()
+	close(configs)
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("retried write: got %v, want ErrDuplicate", err)
+		}
+	}
+	if len(configs) != 1 {
+		t.Fatalf("%d of %d attempts created a config, want one", len(configs), attempts)
+	}
+	config := <-configs
+	if keys := fake.Keys(allConfigs + "/"); len(keys) != 1 {
+		t.Errorf("store holds configs %v, want one", keys)
+	}
+
+	request, err := store.FindRequest(ctx, "retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request == nil || len(request.Changes) != 1 {
+		t.Fatalf("request records %+v, want the created config", request)
+	}
+	if change := request.Changes[0]; change.ID != config.ID || change.Version != "v1" || change.Operation != EventCreate {
+		t.Errorf("request records %+v, want the creation of %s", change, config.ID)
+	}
+}
+
+func TestRetriedVersionIsFoundUnderItsKey(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := WithRequestId(context.Background(), "version")
+
+	config := createConfig(t, store, "a", "v1")
+	first, err := store.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: Entries{"k": "first"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: Entries{"k": "second"}}); err == nil {
+		t.Fatal("retried version was written again")
+	}
+
+	request, err := store.FindRequest(ctx, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request == nil || len(request.Changes) != 1 || request.Changes[0].Version != first.Version {
+		t.Errorf("request records %+v, want the first version", request)
+	}
+
+	if request, err := store.FindRequest(ctx, "unknown"); err != nil || request != nil {
+		t.Errorf("unused key: got %+v, %v, want nothing", request, err)
+	}
+}
@@ -0,0 +1,85 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// SaveSnapshot writes a point-in-time snapshot of the whole backend to w.
+func (cs *ConfigStore) SaveSnapshot(ctx context.Context, w io.Writer) error {
+	span := tracer.StartSpanFromContext(ctx, "SaveSnapshot")
+	defer span.Finish()
+
+	snap, _, err := cs.cli.Snapshot().Save(nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	defer snap.Close()
+
+	_, err = io.Copy(w, snap)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// RestoreSnapshot replaces the whole backend state with the snapshot read
+// from r and records that it was restored from the snapshot called name.
+func (cs *ConfigStore) RestoreSnapshot(ctx context.Context, name string, r io.Reader) (*RestoreMarker, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreSnapshot")
+	defer span.Finish()
+
+	err := cs.cli.Snapshot().Restore(nil, r)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	marker := &RestoreMarker{Snapshot: name, RestoredAt: time.Now().UTC()}
+	data, err := json.Marshal(marker)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	_, err = cs.cli.KV().Put(&api.KVPair{Key: restoredFrom, Value: data}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return marker, nil
+}
+
+// RestoredFrom returns which snapshot the backend was last restored from, or
+// nil if it never was.
+func (cs *ConfigStore) RestoredFrom(ctx context.Context) (*RestoreMarker, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoredFrom")
+	defer span.Finish()
+
+	pair, _, err := cs.cli.KV().Get(restoredFrom, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	marker := &RestoreMarker{}
+	err = json.Unmarshal(pair.Value, marker)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return marker, nil
+}
@@ -0,0 +1,191 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// trash returns the operation that moves records into the trash below prefix.
+// It is meant to be committed together with the deletion of those records.
+// Every deletion gets a key of its own, named after the time it happened, so
+// deleting a recreated version doesn't replace what an earlier delete kept.
+func (cs *ConfigStore) trash(ctx context.Context, prefix string, records []*Record) (*api.KVTxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "trash")
+	defer span.Finish()
+
+	now := time.Now().UTC()
+	data, err := json.Marshal(&TrashRecord{
+		DeletedAt: now,
+		ExpiresAt: now.Add(cs.retention),
+		Records:   records,
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// Index 0 makes the check-and-set fail rather than overwrite another
+	// deletion made at the same instant.
+	key := prefix + fmt.Sprintf(trashEntry, now.UnixNano())
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: data, Index: 0}, nil
+}
+
+func (cs *ConfigStore) RestoreConfig(ctx context.Context, id, ver string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ops, _, err := cs.restoreOps(childCtx, constructTrashConfigKey(childCtx, id, ver))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	err = cs.commit(childCtx, ops, configEvent(EventCreate, &Config{ID: id, Version: ver}))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	config, err := cs.FindConf(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (cs *ConfigStore) RestoreGroup(ctx context.Context, id, ver string) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ops, trashed, err := cs.restoreOps(childCtx, constructTrashGroupKey(childCtx, id, ver))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The event carries the labels of the members, which are only known
+	// from the trashed group record.
+	group := &Group{ID: id, Version: ver}
+	key := constructGroupKey(childCtx, id, ver)
+	for _, record := range trashed.Records {
+		if record.Key != key {
+			continue
+		}
+		err = unmarshalGroup(record.Value, group)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	err = cs.commit(childCtx, ops, groupEvent(EventCreate, group))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	group, err = cs.FindGroup(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// restoreOps returns the operations that put back every record held by the
+// latest unexpired deletion in the trash below prefix. Earlier deletions stay
+// in the trash. Committing the operations fails with ErrConflict if any of
+// the keys has been recreated in the meantime.
+func (cs *ConfigStore) restoreOps(ctx context.Context, prefix string) (api.KVTxnOps, *TrashRecord, error) {
+	span := tracer.StartSpanFromContext(ctx, "restoreOps")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	pairs, _, err := kv.List(prefix, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, unavailable(err)
+	}
+
+	// Keys sort by the time of the deletion, so the latest one comes last.
+	now := time.Now()
+	var pair *api.KVPair
+	trashed := &TrashRecord{}
+	for i := len(pairs) - 1; i >= 0; i-- {
+		err = json.Unmarshal(pairs[i].Value, trashed)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, nil, err
+		}
+		if now.Before(trashed.ExpiresAt) {
+			pair = pairs[i]
+			break
+		}
+	}
+	if pair == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex},
+	}
+
+	// Index 0 makes the check-and-set succeed only if the key doesn't exist.
+	for _, record := range trashed.Records {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: record.Key, Value: record.Value, Index: 0})
+	}
+
+	return ops, trashed, nil
+}
+
+// PurgeTrash permanently removes trashed records whose retention period has
+// passed and returns how many were removed.
+func (cs *ConfigStore) PurgeTrash(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "PurgeTrash")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	pairs, _, err := kv.List(allTrash, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, pair := range pairs {
+		trashed := &TrashRecord{}
+		err := json.Unmarshal(pair.Value, trashed)
+		if err != nil {
+			tracer.LogError(span, err)
+			continue
+		}
+
+		if now.Before(trashed.ExpiresAt) {
+			continue
+		}
+
+		ok, _, err := kv.DeleteCAS(pair, nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
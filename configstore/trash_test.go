@@ -0,0 +1,72 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeletingARecreatedVersionKeepsBothDeletions(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		_, err := store.UpdateConfigVersion(ctx, &Config{ID: "a", Version: "v1", Entries: Entries{"k": value}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.DeleteConfig(ctx, "a", "v1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if keys := fake.Keys("trash/config/a/v1/"); len(keys) != 2 {
+		t.Fatalf("trash holds %v, want two deletions", keys)
+	}
+
+	config, err := store.RestoreConfig(ctx, "a", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Entries["k"] != "second" {
+		t.Errorf("restored %q, want the latest deletion", config.Entries["k"])
+	}
+
+	if _, err := store.RestoreConfig(ctx, "a", "v1"); !errors.Is(err, ErrConflict) {
+		t.Errorf("restoring over the restored version: got %v, want ErrConflict", err)
+	}
+	if keys := fake.Keys("trash/config/a/v1/"); len(keys) != 1 {
+		t.Errorf("trash holds %v after restoring, want the earlier deletion", keys)
+	}
+}
+
+func TestRestoreGroupWithMoreMembersThanATransactionHolds(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	n := txnLimit + 36
+	group, err := store.CreateGroup(ctx, &Group{Version: "v1", Configs: members(n, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteGroup(ctx, group.ID, "v1", 0); err != nil {
+		t.Fatalf("deleting a group of %d members: %v", n, err)
+	}
+
+	restored, err := store.RestoreGroup(ctx, group.ID, "v1")
+	if err != nil {
+		t.Fatalf("restoring a group of %d members: %v", n, err)
+	}
+	if len(restored.Configs) != n {
+		t.Errorf("restored %d members, want %d", len(restored.Configs), n)
+	}
+
+	gold, err := store.FindLabels(ctx, group.ID, "v1", map[string]string{"tier": "gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gold) != (n+9)/10 {
+		t.Errorf("found %d gold members after restoring, want %d", len(gold), (n+9)/10)
+	}
+}
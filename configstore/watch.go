@@ -0,0 +1,134 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// MaxWait is the longest a blocking query may wait, as capped by Consul.
+const MaxWait = 10 * time.Minute
+
+// queryOptions returns the options of a blocking query that waits up to wait
+// for the data to move past index. A zero index doesn't block.
+func queryOptions(ctx context.Context, index uint64, wait time.Duration) *api.QueryOptions {
+	q := &api.QueryOptions{WaitIndex: index, WaitTime: wait}
+	return q.WithContext(ctx)
+}
+
+// WatchConf reads a config version once its index differs from index or
+// wait has passed, and returns it with the index to watch next.
+func (cs *ConfigStore) WatchConf(ctx context.Context, id, ver string, index uint64, wait time.Duration) (*Config, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WatchConf")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	kv := cs.cli.KV()
+	key := constructConfigKey(childCtx, id, ver)
+	data, meta, err := kv.Get(key, queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, unavailable(err)
+	}
+	if data == nil {
+		return nil, meta.LastIndex, ErrNotFound
+	}
+
+	config := &Config{}
+	err = json.Unmarshal(data.Value, config)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	config.State = storedState(config.State)
+	config.Index = data.ModifyIndex
+
+	return config, meta.LastIndex, nil
+}
+
+// WatchGroup is WatchConf for group versions.
+func (cs *ConfigStore) WatchGroup(ctx context.Context, id, ver string, index uint64, wait time.Duration) (*Group, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WatchGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	kv := cs.cli.KV()
+	key := constructGroupKey(childCtx, id, ver)
+	data, meta, err := kv.Get(key, queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, unavailable(err)
+	}
+	if data == nil {
+		return nil, meta.LastIndex, ErrNotFound
+	}
+
+	group := &Group{}
+	err = unmarshalGroup(data.Value, group)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	group.State = storedState(group.State)
+	group.Index = data.ModifyIndex
+
+	return group, meta.LastIndex, nil
+}
+
+// WatchLabels returns the group members matching labels once the members of
+// the group version change past index or wait has passed.
+func (cs *ConfigStore) WatchLabels(ctx context.Context, id, ver string, labels map[string]string, index uint64, wait time.Duration) ([]*GroupConfig, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WatchLabels")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	kv := cs.cli.KV()
+	data, meta, err := kv.Get(constructGroupIndexKey(childCtx, id, ver), queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, unavailable(err)
+	}
+	if data == nil {
+		// Groups written before members had labels of their own have no
+		// index until they are written again, which creates it and thereby
+		// ends the wait of the next call. Their members are matched from
+		// the group itself meanwhile.
+		group, _, err := cs.WatchGroup(childCtx, id, ver, 0, 0)
+		if errors.Is(err, ErrNotFound) {
+			return []*GroupConfig{}, meta.LastIndex, nil
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+
+		configs := []*GroupConfig{}
+		for _, config := range group.Configs {
+			if sameLabels(config.Labels, labels) {
+				configs = append(configs, config)
+			}
+		}
+		return configs, meta.LastIndex, nil
+	}
+
+	members := labelIndex{}
+	err = json.Unmarshal(data.Value, &members)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	configs := members[encodeLabels(labels)]
+	if configs == nil {
+		configs = []*GroupConfig{}
+	}
+
+	return configs, meta.LastIndex, nil
+}
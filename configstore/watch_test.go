@@ -0,0 +1,80 @@
+package configstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatchConfReturnsOnceChanged(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	config := createConfig(t, store, "a", "v1")
+
+	updated := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := store.UpdateConfig(ctx, &Config{ID: "a", Version: "v1", Entries: Entries{"k": "w"}})
+		updated <- err
+	}()
+
+	got, index, err := store.WatchConf(ctx, "a", "v1", config.Index, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+	if index <= config.Index || got.Entries["k"] != "w" {
+		t.Errorf("watch returned %v at index %d after %d", got.Entries, index, config.Index)
+	}
+}
+
+func TestWatchConfReturnsAfterWait(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	config := createConfig(t, store, "a", "v1")
+
+	_, index, err := store.WatchConf(context.Background(), "a", "v1", config.Index, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != config.Index {
+		t.Errorf("unchanged config watched to index %d, want %d", index, config.Index)
+	}
+}
+
+func TestWatchLabelsSeesAddedMembers(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	prod := map[string]string{"env": "prod"}
+	group, err := store.CreateGroup(ctx, &Group{Version: "v1", Configs: []*GroupConfig{{Labels: prod, Entries: Entries{"k": "a"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, index, err := store.WatchLabels(ctx, group.ID, "v1", prod, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _, err := store.AddLabelsToGroup(ctx, []*GroupConfig{{Labels: prod, Entries: Entries{"k": "b"}}}, group.ID, "v1", 0)
+		added <- err
+	}()
+
+	configs, _, err := store.WatchLabels(ctx, group.ID, "v1", prod, index, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Errorf("watch returned %d members, want 2", len(configs))
+	}
+}
@@ -0,0 +1,407 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/hashicorp/consul/api"
+)
+
+// ResourceWebhook names webhooks in the changes recorded under an
+// idempotency key.
+const ResourceWebhook = "webhook"
+
+// Limits on what is kept per webhook: the deliveries of its history and its
+// dead letters. The oldest are dropped past them, at most dropLimit with
+// every delivery saved, so that the transaction doing so stays small.
+const (
+	historyLimit    = 100
+	deadLetterLimit = 1000
+	dropLimit       = 16
+)
+
+func (cs *ConfigStore) CreateWebhook(ctx context.Context, hook *Webhook) (*Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateWebhook")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	hook.ID = uuid.New().String()
+	hook.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(hook)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// Webhooks aren't reported by events, the idempotency key ctx carries
+	// is reserved here instead.
+	ops, err := cs.requestOps(childCtx, []*Event{{Resource: ResourceWebhook, ID: hook.ID, Operation: EventCreate}})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: constructWebhookKey(childCtx, hook.ID), Value: data, Index: 0})
+
+	err = cs.commit(WithRequestId(childCtx, ""), ops)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func (cs *ConfigStore) FindWebhook(ctx context.Context, id string) (*Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindWebhook")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	kv := cs.cli.KV()
+	data, _, err := kv.Get(constructWebhookKey(childCtx, id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	hook := &Webhook{}
+	err = json.Unmarshal(data.Value, hook)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func (cs *ConfigStore) FindWebhooks(ctx context.Context) ([]*Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindWebhooks")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	data, _, err := kv.List(allWebhooks, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+
+	hooks := []*Webhook{}
+	for _, pair := range data {
+		hook := &Webhook{}
+		err := json.Unmarshal(pair.Value, hook)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes a webhook along with its pending deliveries, its
+// delivery history and its dead letters.
+func (cs *ConfigStore) DeleteWebhook(ctx context.Context, id string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteWebhook")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if _, err := cs.FindWebhook(childCtx, id); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVDelete, Key: constructWebhookKey(childCtx, id)},
+		&api.KVTxnOp{Verb: api.KVDeleteTree, Key: fmt.Sprintf(webhookDelivery, id, "")},
+		&api.KVTxnOp{Verb: api.KVDeleteTree, Key: fmt.Sprintf(webhookDead, id, "")},
+		&api.KVTxnOp{Verb: api.KVDeleteTree, Key: fmt.Sprintf(webhookPending, id, "")},
+	}
+
+	return cs.commit(childCtx, ops)
+}
+
+// QueueDelivery records d as pending until SaveDelivery reports it delivered
+// or dead. A delivery that is already pending is left as it is, so queueing
+// the same delivery twice sends it once.
+func (cs *ConfigStore) QueueDelivery(ctx context.Context, d *Delivery) error {
+	span := tracer.StartSpanFromContext(ctx, "QueueDelivery")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	_, err := cs.putWithIndex(childCtx, constructPendingKey(childCtx, d.Webhook, d.ID), d, 0)
+	if errors.Is(err, ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// PendingDeliveries returns the deliveries queued and not yet delivered or
+// dead, once they differ from index or wait has passed, together with the
+// index to wait on next.
+func (cs *ConfigStore) PendingDeliveries(ctx context.Context, index uint64, wait time.Duration) ([]*Delivery, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "PendingDeliveries")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	data, meta, err := kv.List(allPending, queryOptions(ctx, index, wait))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, unavailable(err)
+	}
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].CreateIndex < data[j].CreateIndex
+	})
+
+	deliveries := []*Delivery{}
+	for _, pair := range data {
+		d := &Delivery{}
+		err := json.Unmarshal(pair.Value, d)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, meta.LastIndex, nil
+}
+
+// SaveDelivery adds d to the delivery history of its webhook, dropping the
+// oldest entries past historyLimit. Dead deliveries are kept as dead letters
+// as well, up to deadLetterLimit of them. Delivered and dead deliveries are
+// no longer pending, while a failed one stays pending with its attempts and
+// when it is due next. Saving fails with ErrConflict once the webhook has
+// been deleted.
+func (cs *ConfigStore) SaveDelivery(ctx context.Context, d *Delivery) error {
+	span := tracer.StartSpanFromContext(ctx, "SaveDelivery")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	drop, err := cs.dropOps(childCtx, d)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVGet, Key: constructWebhookKey(childCtx, d.Webhook)},
+		&api.KVTxnOp{Verb: api.KVSet, Key: constructDeliveryKey(childCtx, d), Value: data},
+	}
+	pending := constructPendingKey(childCtx, d.Webhook, d.ID)
+	switch d.Status {
+	case Failed:
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: pending, Value: data})
+	case Dead:
+		ops = append(ops,
+			&api.KVTxnOp{Verb: api.KVSet, Key: constructDeadLetterKey(childCtx, d.Webhook, d.ID), Value: data},
+			&api.KVTxnOp{Verb: api.KVDelete, Key: pending},
+		)
+	case Delivered:
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDelete, Key: pending})
+	}
+
+	err = cs.commit(childCtx, append(ops, drop...))
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// dropOps returns the operations that drop the oldest deliveries and dead
+// letters of the webhook of d, so that saving d keeps them within their
+// limits.
+func (cs *ConfigStore) dropOps(ctx context.Context, d *Delivery) (api.KVTxnOps, error) {
+	span := tracer.StartSpanFromContext(ctx, "dropOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	// Delivery keys sort by creation time. A delivery saved again after a
+	// failed attempt keeps its key and takes no more room.
+	kv := cs.cli.KV()
+	history, _, err := kv.Keys(fmt.Sprintf(webhookDelivery, d.Webhook, ""), "", nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+	sort.Strings(history)
+	keep := historyLimit - 1
+	for _, key := range history {
+		if key == constructDeliveryKey(childCtx, d) {
+			keep++
+		}
+	}
+	ops := dropOldest(history, keep)
+
+	if d.Status != Dead {
+		return ops, nil
+	}
+
+	dead, err := cs.FindDeadLetters(childCtx, d.Webhook)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	keys := make([]string, 0, len(dead))
+	for _, letter := range dead {
+		if letter.ID != d.ID {
+			keys = append(keys, constructDeadLetterKey(childCtx, d.Webhook, letter.ID))
+		}
+	}
+	return append(ops, dropOldest(keys, deadLetterLimit-1)...), nil
+}
+
+// dropOldest returns the operations that delete the first keys, oldest
+// first, past the keep last ones, but no more than dropLimit of them.
+func dropOldest(keys []string, keep int) api.KVTxnOps {
+	var ops api.KVTxnOps
+	for i := 0; i < len(keys)-keep && i < dropLimit; i++ {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDelete, Key: keys[i]})
+	}
+	return ops
+}
+
+// FindDeliveries returns the delivery history of a webhook, newest first.
+func (cs *ConfigStore) FindDeliveries(ctx context.Context, hook string) ([]*Delivery, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindDeliveries")
+	defer span.Finish()
+
+	deliveries, err := cs.listDeliveries(ctx, fmt.Sprintf(webhookDelivery, hook, ""))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	for i, j := 0, len(deliveries)-1; i < j; i, j = i+1, j-1 {
+		deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+	}
+	return deliveries, nil
+}
+
+// FindDeadLetters returns the deliveries of a webhook that ran out of
+// retries.
+func (cs *ConfigStore) FindDeadLetters(ctx context.Context, hook string) ([]*Delivery, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindDeadLetters")
+	defer span.Finish()
+
+	deliveries, err := cs.listDeliveries(ctx, fmt.Sprintf(webhookDead, hook, ""))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+// RedeliverDeadLetter replaces a dead letter with a pending delivery of its
+// event, with a new ID and a fresh set of attempts, and returns the new
+// delivery. Both happen in one transaction, so the event is never lost in
+// between.
+func (cs *ConfigStore) RedeliverDeadLetter(ctx context.Context, hook, id string) (*Delivery, error) {
+	span := tracer.StartSpanFromContext(ctx, "RedeliverDeadLetter")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	kv := cs.cli.KV()
+	pair, _, err := kv.Get(constructDeadLetterKey(childCtx, hook, id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+	if pair == nil {
+		return nil, ErrNotFound
+	}
+
+	dead := &Delivery{}
+	err = json.Unmarshal(pair.Value, dead)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	d := &Delivery{
+		ID:        uuid.New().String(),
+		Webhook:   hook,
+		Event:     dead.Event,
+		Status:    Pending,
+		CreatedAt: time.Now().UTC(),
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The dead letter is taken at the index it was read at, so that two
+	// redeliveries of it can't both go through.
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVGet, Key: constructWebhookKey(childCtx, hook)},
+		&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex},
+		&api.KVTxnOp{Verb: api.KVCAS, Key: constructPendingKey(childCtx, hook, d.ID), Value: data, Index: 0},
+	}
+	err = cs.commit(childCtx, ops)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (cs *ConfigStore) listDeliveries(ctx context.Context, prefix string) ([]*Delivery, error) {
+	span := tracer.StartSpanFromContext(ctx, "listDeliveries")
+	defer span.Finish()
+
+	kv := cs.cli.KV()
+	data, _, err := kv.List(prefix, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, unavailable(err)
+	}
+
+	deliveries := []*Delivery{}
+	for _, pair := range data {
+		d := &Delivery{}
+		err := json.Unmarshal(pair.Value, d)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,172 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueuedDeliveryIsPendingUntilSaved(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	hook, err := store.CreateWebhook(ctx, &Webhook{URL: "http://example.com/hook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Delivery{ID: "d1", Webhook: hook.ID, Event: &Event{Seq: 1}, Status: Pending}
+	for i := 0; i < 2; i++ {
+		if err := store.QueueDelivery(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending, _, err := store.PendingDeliveries(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("%d deliveries pending after queueing one twice", len(pending))
+	}
+
+	next := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	d.Status, d.Attempts, d.NextAttempt = Failed, 1, next
+	if err := store.SaveDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	pending, _, err = store.PendingDeliveries(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || !pending[0].NextAttempt.Equal(next) {
+		t.Fatalf("pending after a failed attempt: %+v", pending)
+	}
+
+	d.Status, d.Attempts = Delivered, 2
+	if err := store.SaveDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _, _ = store.PendingDeliveries(ctx, 0, 0); len(pending) != 0 {
+		t.Errorf("%d deliveries pending after delivering", len(pending))
+	}
+}
+
+func TestSavingADeliveryOfADeletedWebhookFails(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	hook, err := store.CreateWebhook(ctx, &Webhook{URL: "http://example.com/hook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Delivery{ID: "d1", Webhook: hook.ID, Event: &Event{Seq: 1}, Status: Pending}
+	if err := store.QueueDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteWebhook(ctx, hook.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Status, d.Attempts = Failed, 1
+	if err := store.SaveDelivery(ctx, d); !errors.Is(err, ErrConflict) {
+		t.Errorf("saving a delivery of a deleted webhook: got %v, want ErrConflict", err)
+	}
+	if keys := fake.Keys("webhook"); len(keys) != 0 {
+		t.Errorf("store holds %v after deleting the webhook", keys)
+	}
+}
+
+func TestRedeliveringADeadLetterQueuesItsEventOnce(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	hook, err := store.CreateWebhook(ctx, &Webhook{URL: "http://example.com/hook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Delivery{ID: "d1", Webhook: hook.ID, Event: &Event{Seq: 7}, Status: Pending}
+	if err := store.QueueDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	d.Status, d.Attempts = Dead, 5
+	if err := store.SaveDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := store.RedeliverDeadLetter(ctx, hook.ID, d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == d.ID || again.Status != Pending || again.Attempts != 0 || again.Event.Seq != 7 {
+		t.Errorf("redelivery: %+v", again)
+	}
+
+	pending, _, err := store.PendingDeliveries(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != again.ID {
+		t.Errorf("pending after redelivering: %+v", pending)
+	}
+	if dead, _ := store.FindDeadLetters(ctx, hook.ID); len(dead) != 0 {
+		t.Errorf("%d dead letters left after redelivering", len(dead))
+	}
+
+	if _, err := store.RedeliverDeadLetter(ctx, hook.ID, d.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("redelivering twice: got %v, want ErrNotFound", err)
+	}
+	if pending, _, _ = store.PendingDeliveries(ctx, 0, 0); len(pending) != 1 {
+		t.Errorf("%d deliveries pending after redelivering twice", len(pending))
+	}
+}
+
+func TestDeliveryHistoryIsCapped(t *testing.T) {
+	store, fake := newTestStore(t)
+	ctx := context.Background()
+
+	hook, err := store.CreateWebhook(ctx, &Webhook{URL: "http://example.com/hook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now().UTC()
+	for i := 0; i < historyLimit+dropLimit/2; i++ {
+		d := &Delivery{
+			ID:        fmt.Sprintf("d%03d", i),
+			Webhook:   hook.ID,
+			Event:     &Event{Seq: uint64(i)},
+			Status:    Delivered,
+			CreatedAt: created.Add(time.Duration(i) * time.Second),
+		}
+		if err := store.SaveDelivery(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Saving the same delivery again takes no more room.
+			if err := store.SaveDelivery(ctx, d); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	history, err := store.FindDeliveries(ctx, hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != historyLimit {
+		t.Fatalf("%d deliveries kept, want %d", len(history), historyLimit)
+	}
+	for _, d := range history {
+		if d.Event.Seq < dropLimit/2 {
+			t.Errorf("delivery %s was kept over newer ones", d.ID)
+		}
+	}
+	if keys := fake.Keys("webhook-delivery/"); len(keys) != historyLimit {
+		t.Errorf("store holds %d deliveries, want %d", len(keys), historyLimit)
+	}
+}
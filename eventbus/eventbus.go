@@ -0,0 +1,65 @@
+// Package eventbus publishes the change events of the config store to a
+// message bus, so that other services can react to them. NATS and Kafka are
+// supported.
+package eventbus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+const (
+	NATS  = "nats"
+	Kafka = "kafka"
+)
+
+// defaultTopic is the NATS subject prefix or the Kafka topic events are
+// published to unless EVENT_BUS_TOPIC says otherwise.
+const defaultTopic = "configstore"
+
+// publishTimeout bounds how long Publish waits for the bus to take an event.
+const publishTimeout = 10 * time.Second
+
+var ErrUnknownBus = errors.New("Unknown event bus")
+
+// Publisher sends events to a message bus. Publish returns once the bus has
+// taken the event, and an error if it didn't within publishTimeout, so that
+// the store dispatches the event again.
+type Publisher interface {
+	Publish(event *cs.Event) error
+	Close() error
+}
+
+// New connects to the bus of the given kind. For NATS url is a server URL,
+// for Kafka a comma separated list of brokers; an empty url uses the
+// default of the bus.
+func New(kind, url, topic string) (Publisher, error) {
+	if topic == "" {
+		topic = defaultTopic
+	}
+
+	switch kind {
+	case NATS:
+		return newNATSPublisher(url, topic)
+	case Kafka:
+		return newKafkaPublisher(url, topic)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownBus, kind)
+}
+
+// FromEnv connects to the bus named by EVENT_BUS at EVENT_BUS_URL, using
+// EVENT_BUS_TOPIC as the topic. It returns a nil Publisher when EVENT_BUS
+// isn't set.
+func FromEnv() (Publisher, error) {
+	kind := strings.ToLower(os.Getenv("EVENT_BUS"))
+	if kind == "" {
+		return nil, nil
+	}
+
+	return New(kind, os.Getenv("EVENT_BUS_URL"), os.Getenv("EVENT_BUS_TOPIC"))
+}
@@ -0,0 +1,61 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/segmentio/kafka-go"
+)
+
+const defaultBroker = "localhost:9092"
+
+// kafkaPublisher publishes events to a single topic. Messages are keyed by
+// resource and ID, so the events of a config or group keep their order
+// within a partition.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(brokers, topic string) (*kafkaPublisher, error) {
+	if brokers == "" {
+		brokers = defaultBroker
+	}
+
+	// Every event is written on its own and acknowledged by all in-sync
+	// replicas before Publish returns, rather than waiting to fill a batch.
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    1,
+		RequiredAcks: kafka.RequireAll,
+		WriteTimeout: publishTimeout,
+	}
+
+	return &kafkaPublisher{writer: writer}, nil
+}
+
+func (p *kafkaPublisher) Publish(event *cs.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.Resource + "/" + event.ID),
+		Value: data,
+		Headers: []kafka.Header{
+			{Key: "event", Value: []byte(event.Resource + "." + event.Operation)},
+		},
+	})
+}
+
+// Close waits for writes in progress before closing the writer.
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
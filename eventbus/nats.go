@@ -0,0 +1,51 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/nats-io/nats.go"
+)
+
+// natsPublisher publishes every event on a subject named after its resource
+// and operation, e.g. "configstore.config.update", so that subscribers can
+// pick what they are interested in with wildcards.
+type natsPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func newNATSPublisher(url, prefix string) (*natsPublisher, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(url, nats.Name("configstore"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsPublisher{conn: conn, prefix: prefix}, nil
+}
+
+func (p *natsPublisher) Publish(event *cs.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = p.conn.Publish(fmt.Sprintf("%s.%s.%s", p.prefix, event.Resource, event.Operation), data)
+	if err != nil {
+		return err
+	}
+
+	// Publish only buffers the message. The round trip of a flush makes
+	// sure the server has received it, and fails while reconnecting.
+	return p.conn.FlushTimeout(publishTimeout)
+}
+
+// Close flushes the events not yet sent before closing the connection.
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
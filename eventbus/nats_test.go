@@ -0,0 +1,60 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATSPublishesOnResourceSubjects(t *testing.T) {
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	srv := test.RunServer(&opts)
+	defer srv.Shutdown()
+
+	sub, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	msgs := make(chan *nats.Msg, 1)
+	if _, err := sub.ChanSubscribe(defaultTopic+".>", msgs); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(NATS, srv.ClientURL(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	event := &cs.Event{Seq: 7, Resource: cs.ResourceConfig, ID: "db", Version: "v1", Operation: cs.EventUpdate}
+	if err := p.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-msgs:
+		if want := "configstore.config.update"; msg.Subject != want {
+			t.Errorf("published on %q, want %q", msg.Subject, want)
+		}
+
+		got := &cs.Event{}
+		if err := json.Unmarshal(msg.Data, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Seq != event.Seq || got.ID != event.ID || got.Version != event.Version || got.Operation != event.Operation {
+			t.Errorf("published %+v, want %+v", got, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
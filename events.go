@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+)
+
+const (
+	eventBuffer    = 1024
+	eventHeartbeat = 15 * time.Second
+)
+
+// eventHub fans the events of the store out to subscribers. The latest
+// events are kept so that a subscriber can resume after the last event it
+// has seen.
+type eventHub struct {
+	mu     sync.Mutex
+	recent []*cs.Event
+	subs   map[chan *cs.Event]bool
+	// horizon is the number of the latest event the hub may not have kept,
+	// either because it was dropped from recent or because the hub never
+	// saw it.
+	horizon uint64
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan *cs.Event]bool)}
+}
+
+func (h *eventHub) publish(event *cs.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.recent = append(h.recent, event)
+	if len(h.recent) > eventBuffer {
+		h.horizon = h.recent[len(h.recent)-eventBuffer-1].Seq
+		h.recent = h.recent[len(h.recent)-eventBuffer:]
+	}
+
+	// A subscriber that can't keep up is dropped rather than holding up the
+	// store; it can resume from the last event it received.
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// miss records that the events up to seq may never reach the hub. Every
+// subscriber is dropped, so that it resumes and learns about the gap.
+func (h *eventHub) miss(seq uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if seq <= h.horizon {
+		return
+	}
+	h.horizon = seq
+
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// subscribe returns the kept events after since and a channel receiving
+// every later event. The channel is closed when the subscriber is dropped.
+// When events after since may be missing, the backlog starts with a reset
+// event numbered after the latest of them.
+func (h *eventHub) subscribe(since uint64) ([]*cs.Event, chan *cs.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []*cs.Event
+	if since != 0 && since < h.horizon {
+		backlog = append(backlog, &cs.Event{Seq: h.horizon, Operation: cs.EventReset, Time: time.Now().UTC()})
+	}
+	for _, event := range h.recent {
+		if event.Seq > since {
+			backlog = append(backlog, event)
+		}
+	}
+
+	ch := make(chan *cs.Event, 64)
+	h.subs[ch] = true
+	return backlog, ch
+}
+
+func (h *eventHub) unsubscribe(ch chan *cs.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[ch] {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// close drops every subscriber so that open streams end on shutdown.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// eventFilter selects events by config ID, group ID, version and a label
+// selector given as "k=v,k2=v2". Events pass if they concern one of the
+// given configs or groups, and if a member of the group carries every label
+// of the selector.
+type eventFilter struct {
+	config   string
+	group    string
+	version  string
+	selector map[string]string
+}
+
+func parseEventFilter(r *http.Request) (*eventFilter, error) {
+	query := r.URL.Query()
+	filter := &eventFilter{
+		config: query.Get("config"),
+		group:  query.Get("group"),
+	}
+
+	if value := query.Get("selector"); value != "" {
+		selector, err := parseSelector(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.selector = selector
+	}
+
+	return filter, nil
+}
+
+func parseSelector(value string) (map[string]string, error) {
+	values, err := url.ParseQuery(strings.ReplaceAll(value, ",", "&"))
+	if err != nil {
+		return nil, err
+	}
+
+	selector := make(map[string]string)
+	for k, v := range values {
+		selector[k] = v[0]
+	}
+	return selector, nil
+}
+
+func (f *eventFilter) match(event *cs.Event) bool {
+	if event.Operation == cs.EventReset {
+		return true
+	}
+
+	if f.config != "" || f.group != "" {
+		if !(event.Resource == cs.ResourceConfig && event.ID == f.config) &&
+			!(event.Resource == cs.ResourceGroup && event.ID == f.group) {
+			return false
+		}
+	}
+
+	if f.version != "" && event.Version != f.version {
+		return false
+	}
+
+	if len(f.selector) == 0 {
+		return true
+	}
+
+	for _, labels := range event.Labels {
+		if containsLabels(labels, f.selector) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (ts *Service) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("eventsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling events at %s\n", req.URL.Path)),
+	)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Streaming is not supported")
+		return
+	}
+
+	filter, err := parseEventFilter(req)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, "Invalid selector")
+		return
+	}
+
+	var since uint64
+	if value := req.Header.Get("Last-Event-ID"); value != "" {
+		since, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, codeMalformed, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	backlog, events := ts.events.subscribe(since)
+	defer ts.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	for _, event := range backlog {
+		if filter.match(event) {
+			if err := writeEvent(ctx, w, event); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(event) {
+				continue
+			}
+			if err := writeEvent(ctx, w, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes event as a server-sent event named after its resource
+// and operation, e.g. "config.update", or "reset" for a reset event.
+func writeEvent(ctx context.Context, w http.ResponseWriter, event *cs.Event) error {
+	span := tracer.StartSpanFromContext(ctx, "writeEvent")
+	defer span.Finish()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	name := event.Resource + "." + event.Operation
+	if event.Operation == cs.EventReset {
+		name = cs.EventReset
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, name, data)
+	return err
+}
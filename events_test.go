@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestResumeBeforeDroppedEventsStartsWithReset(t *testing.T) {
+	h := newEventHub()
+	n := uint64(eventBuffer + 10)
+	for seq := uint64(1); seq <= n; seq++ {
+		h.publish(&cs.Event{Seq: seq, Resource: cs.ResourceConfig, Operation: cs.EventUpdate})
+	}
+
+	backlog, ch := h.subscribe(5)
+	h.unsubscribe(ch)
+	if len(backlog) != eventBuffer+1 {
+		t.Fatalf("backlog of %d events, want %d", len(backlog), eventBuffer+1)
+	}
+	if reset := backlog[0]; reset.Operation != cs.EventReset || reset.Seq != n-eventBuffer {
+		t.Errorf("backlog starts with %s %d, want reset %d", reset.Operation, reset.Seq, n-eventBuffer)
+	}
+	if backlog[1].Seq != n-eventBuffer+1 {
+		t.Errorf("first kept event %d, want %d", backlog[1].Seq, n-eventBuffer+1)
+	}
+
+	// A stream resumed after the dropped events, or not resumed at all, has
+	// missed nothing.
+	for _, since := range []uint64{0, n - eventBuffer, n - 1} {
+		backlog, ch := h.subscribe(since)
+		h.unsubscribe(ch)
+		if len(backlog) > 0 && backlog[0].Operation == cs.EventReset {
+			t.Errorf("stream resumed after %d starts with a reset", since)
+		}
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	prod := map[string]string{"env": "prod"}
+	events := []*cs.Event{
+		{Resource: cs.ResourceConfig, ID: "a", Operation: cs.EventUpdate},
+		{Resource: cs.ResourceGroup, ID: "g", Operation: cs.EventUpdate, Labels: []map[string]string{prod}},
+		{Resource: cs.ResourceGroup, ID: "h", Operation: cs.EventUpdate, Labels: []map[string]string{{"env": "dev"}}},
+		{Operation: cs.EventReset},
+	}
+
+	tests := []struct {
+		query string
+		want  []bool
+	}{
+		{"", []bool{true, true, true, true}},
+		{"config=a", []bool{true, false, false, true}},
+		{"group=g&config=a", []bool{true, true, false, true}},
+		{"selector=env=prod", []bool{false, true, false, true}},
+	}
+
+	for _, test := range tests {
+		filter, err := parseEventFilter(httptest.NewRequest(http.MethodGet, "/events?"+test.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, event := range events {
+			if got := filter.match(event); got != test.want[i] {
+				t.Errorf("%q: event %d matched %v, want %v", test.query, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestWriteEventNamesResets(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := writeEvent(context.Background(), w, &cs.Event{Seq: 7, Operation: cs.EventReset}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(w.Body.String(), "id: 7\nevent: reset\n") {
+		t.Errorf("reset written as %q", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	if err := writeEvent(context.Background(), w, &cs.Event{Seq: 8, Resource: cs.ResourceGroup, Operation: cs.EventDelete}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(w.Body.String(), "id: 8\nevent: group.delete\n") {
+		t.Errorf("group delete written as %q", w.Body)
+	}
+}
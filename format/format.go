@@ -0,0 +1,242 @@
+// Package format renders configuration entries in the formats served to
+// clients: JSON, YAML, TOML, dotenv and Java properties.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	JSON       = "json"
+	YAML       = "yaml"
+	TOML       = "toml"
+	Dotenv     = "dotenv"
+	Properties = "properties"
+)
+
+var contentTypes = map[string]string{
+	JSON:       "application/json",
+	YAML:       "application/yaml",
+	TOML:       "application/toml",
+	Dotenv:     "text/x-dotenv",
+	Properties: "text/x-java-properties",
+}
+
+var mediaTypes = map[string]string{
+	"application/json":       JSON,
+	"application/yaml":       YAML,
+	"application/x-yaml":     YAML,
+	"text/yaml":              YAML,
+	"application/toml":       TOML,
+	"text/x-dotenv":          Dotenv,
+	"text/x-java-properties": Properties,
+}
+
+var ErrUnknownFormat = errors.New("Unknown format")
+
+// ErrKeyCollision is returned by Encode for entries whose keys become the
+// same variable name in dotenv, which would keep only one of their values.
+var ErrKeyCollision = errors.New("Keys collide")
+
+// ContentType returns the media type a format is served with.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// FromName validates a format name such as the one given in ?format=.
+func FromName(name string) (string, error) {
+	if _, ok := contentTypes[name]; !ok {
+		return "", ErrUnknownFormat
+	}
+	return name, nil
+}
+
+// FromMediaType returns the format served with a media type.
+func FromMediaType(mediatype string) (string, error) {
+	format, ok := mediaTypes[mediatype]
+	if !ok {
+		return "", ErrUnknownFormat
+	}
+	return format, nil
+}
+
+// Decode reads v from r in the given format. Decoding is strict: fields that
+// don't exist in v are rejected in every format.
+func Decode(r io.Reader, format string, v interface{}) error {
+	switch format {
+	case JSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	case YAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	case TOML:
+		// TOML is decoded through JSON so it is held to the same rules,
+		// including those of custom JSON unmarshalers.
+		var tree map[string]interface{}
+		if _, err := toml.NewDecoder(r).Decode(&tree); err != nil {
+			return err
+		}
+		data, err := json.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		return Decode(bytes.NewReader(data), JSON, v)
+	}
+	return ErrUnknownFormat
+}
+
+// Encode writes v to w in the given format. Dotenv and properties can only
+// represent flat string entries, so v must be a map[string]string for them.
+func Encode(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case JSON:
+		return json.NewEncoder(w).Encode(v)
+	case YAML:
+		enc := yaml.NewEncoder(w)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	case TOML:
+		return toml.NewEncoder(w).Encode(v)
+	case Dotenv, Properties:
+		entries, ok := v.(map[string]string)
+		if !ok {
+			return fmt.Errorf("%s can only render flat entries", format)
+		}
+		if format == Dotenv {
+			return encodeDotenv(w, entries)
+		}
+		return encodeProperties(w, entries)
+	}
+	return ErrUnknownFormat
+}
+
+func sortedKeys(entries map[string]string) []string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func encodeDotenv(w io.Writer, entries map[string]string) error {
+	keys := sortedKeys(entries)
+	names := make(map[string]string, len(keys))
+	for _, k := range keys {
+		name := dotenvKey(k)
+		if prev, ok := names[name]; ok {
+			return fmt.Errorf("%w: %q and %q are both written as %s", ErrKeyCollision, prev, k, name)
+		}
+		names[name] = k
+	}
+
+	for _, k := range keys {
+		_, err := fmt.Fprintf(w, "%s=\"%s\"\n", dotenvKey(k), dotenvValue(entries[k]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dotenvKey replaces characters that aren't allowed in environment variable
+// names with underscores.
+func dotenvKey(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// dotenvValue escapes a value for use inside double quotes.
+func dotenvValue(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '\\', '"', '$', '`':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func encodeProperties(w io.Writer, entries map[string]string) error {
+	for _, k := range sortedKeys(entries) {
+		_, err := fmt.Fprintf(w, "%s=%s\n", propertiesEscape(k, true), propertiesEscape(entries[k], false))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propertiesEscape escapes a key or value the way java.util.Properties
+// stores them. Spaces are always escaped in keys and only when leading in
+// values, and characters outside printable ASCII become \uXXXX escapes.
+func propertiesEscape(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case ' ':
+			if key || i == 0 {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\\', '=', ':', '#', '!':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, u := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&b, `\u%04X`, u)
+				}
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDotenvKey(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"DB_HOST", "DB_HOST"},
+		{"db.host", "db_host"},
+		{"db-host", "db_host"},
+		{"a b", "a_b"},
+		{"1st", "_1st"},
+		{"a1", "a1"},
+		{"čvor", "_vor"},
+		{"=#!:", "____"},
+	}
+
+	for _, test := range tests {
+		if got := dotenvKey(test.key); got != test.want {
+			t.Errorf("dotenvKey(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestDotenvValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{`say "hi"`, `say \"hi\"`},
+		{"two\nlines\r", `two\nlines\r`},
+		{"tab\there", `tab\there`},
+		{`$HOME and ${PATH}`, `\$HOME and \${PATH}`},
+		{"`date`", "\\`date\\`"},
+		{`C:\dir`, `C:\\dir`},
+		{"a=b#c:d!e", "a=b#c:d!e"},
+		{"  leading", "  leading"},
+		{"ünïcode", "ünïcode"},
+	}
+
+	for _, test := range tests {
+		if got := dotenvValue(test.value); got != test.want {
+			t.Errorf("dotenvValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestPropertiesEscape(t *testing.T) {
+	tests := []struct {
+		s    string
+		key  bool
+		want string
+	}{
+		{"db.host", true, "db.host"},
+		{"a b", true, `a\ b`},
+		{"a b", false, "a b"},
+		{" leading", true, `\ leading`},
+		{" leading", false, `\ leading`},
+		{"a=b", true, `a\=b`},
+		{"a:b", false, `a\:b`},
+		{"#x", true, `\#x`},
+		{"!x", false, `\!x`},
+		{`back\slash`, false, `back\\slash`},
+		{"two\nlines\r", false, `two\nlines\r`},
+		{"tab\tfeed\f", false, `tab\tfeed\f`},
+		{`say "hi"`, false, `say "hi"`},
+		{"é", false, `\u00E9`},
+		{"😀", true, `\uD83D\uDE00`},
+		{"1st", true, "1st"},
+	}
+
+	for _, test := range tests {
+		if got := propertiesEscape(test.s, test.key); got != test.want {
+			t.Errorf("propertiesEscape(%q, %v) = %q, want %q", test.s, test.key, got, test.want)
+		}
+	}
+}
+
+func TestEncodeDotenv(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, Dotenv, map[string]string{"db.port": "5432", "greeting": "say \"hi\"\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "db_port=\"5432\"\ngreeting=\"say \\\"hi\\\"\\n\"\n"; buf.String() != want {
+		t.Errorf("encoded %q, want %q", buf.String(), want)
+	}
+}
+
+func TestEncodeDotenvRejectsCollidingKeys(t *testing.T) {
+	for _, entries := range []map[string]string{
+		{"db.host": "a", "db_host": "b"},
+		{"db.host": "a", "db-host": "a"},
+		{"_1": "a", "1": "b"},
+	} {
+		var buf bytes.Buffer
+		err := Encode(&buf, Dotenv, entries)
+		if !errors.Is(err, ErrKeyCollision) {
+			t.Errorf("encoded %v as %q, want ErrKeyCollision", entries, buf.String())
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := map[string]string{
+		"db.host":   "localhost",
+		"quote\"":   `say "hi"`,
+		"multiline": "one\ntwo",
+		"ünïcode":   "✓",
+		"1st":       "  leading spaces",
+		"#comment":  "a=b: c # d ! e",
+		"empty":     "",
+		"yes":       "no",
+		"number":    "0123",
+	}
+
+	for _, f := range []string{JSON, YAML, TOML} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, f, entries); err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+
+		var decoded map[string]string
+		if err := Decode(&buf, f, &decoded); err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, entries) {
+			t.Errorf("%s: round trip gave %v, want %v", f, decoded, entries)
+		}
+	}
+}
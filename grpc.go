@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dekeract10/ARS-projekat/configpb"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const grpcAddr = "0.0.0.0:8001"
+
+// configService and groupService serve the gRPC API from the same store as
+// the REST handlers.
+type configService struct {
+	configpb.UnimplementedConfigServiceServer
+	*Service
+}
+
+type groupService struct {
+	configpb.UnimplementedGroupServiceServer
+	*Service
+}
+
+func newGRPCServer(s *Service) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(countGRPCUnary),
+		grpc.StreamInterceptor(countGRPCStream),
+	)
+	configpb.RegisterConfigServiceServer(srv, &configService{Service: s})
+	configpb.RegisterGroupServiceServer(srv, &groupService{Service: s})
+	return srv
+}
+
+// stopGRPC lets the calls in flight finish, cancelling those still running
+// when ctx is done. Watch calls only end when cancelled.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+func (s *configService) CreateConfig(ctx context.Context, req *configpb.CreateConfigRequest) (*configpb.Config, error) {
+	span := tracer.StartSpanFromIncomingContext("CreateConfig", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	if req.Version == "" {
+		return nil, status.Error(codes.InvalidArgument, "Invalid request")
+	}
+
+	ctx = s.withRequestId(ctx)
+	config, err := s.store.CreateConfig(ctx, &cs.Config{Version: req.Version, Entries: entries(req.Entries), State: req.State})
+	if err != nil {
+		config, err = s.replayConfig(ctx, err)
+	}
+	if err != nil {
+		return nil, grpcError(err, "Could not create config")
+	}
+
+	return toConfigPB(config), nil
+}
+
+func (s *configService) CreateConfigVersion(ctx context.Context, req *configpb.CreateConfigRequest) (*configpb.Config, error) {
+	span := tracer.StartSpanFromIncomingContext("CreateConfigVersion", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	if req.Id == "" || req.Version == "" {
+		return nil, status.Error(codes.InvalidArgument, "Invalid request")
+	}
+
+	ctx = s.withRequestId(ctx)
+	config, err := s.store.UpdateConfigVersion(ctx, &cs.Config{ID: req.Id, Version: req.Version, Entries: entries(req.Entries), State: req.State})
+	if err != nil {
+		config, err = s.replayConfig(ctx, err)
+	}
+	if err != nil {
+		return nil, grpcError(err, "Could not create config version")
+	}
+
+	return toConfigPB(config), nil
+}
+
+func (s *configService) GetConfig(ctx context.Context, req *configpb.GetRequest) (*configpb.Config, error) {
+	span := tracer.StartSpanFromIncomingContext("GetConfig", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	config, err := s.store.FindConf(ctx, req.Id, req.Version)
+	if err != nil {
+		return nil, grpcError(err, "Could not get config")
+	}
+
+	return toConfigPB(config), nil
+}
+
+func (s *configService) ListConfigVersions(ctx context.Context, req *configpb.ListVersionsRequest) (*configpb.ConfigList, error) {
+	span := tracer.StartSpanFromIncomingContext("ListConfigVersions", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	configs, err := s.store.FindConfVersions(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err, "Could not list config versions")
+	}
+
+	list := &configpb.ConfigList{}
+	for _, config := range configs {
+		list.Configs = append(list.Configs, toConfigPB(config))
+	}
+	return list, nil
+}
+
+func (s *configService) DeleteConfig(ctx context.Context, req *configpb.DeleteRequest) (*emptypb.Empty, error) {
+	span := tracer.StartSpanFromIncomingContext("DeleteConfig", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := s.store.DeleteConfig(ctx, req.Id, req.Version, req.Match)
+	if err != nil {
+		return nil, grpcError(err, "Could not delete config")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *configService) DeleteConfigVersions(ctx context.Context, req *configpb.DeleteVersionsRequest) (*emptypb.Empty, error) {
+	span := tracer.StartSpanFromIncomingContext("DeleteConfigVersions", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	err := s.store.DeleteConfigVersions(ctx, req.Id, req.Force)
+	if err != nil {
+		return nil, grpcError(err, "Could not delete config versions")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *configService) Watch(req *configpb.WatchRequest, stream configpb.ConfigService_WatchServer) error {
+	span := tracer.StartSpanFromIncomingContext("WatchConfig", s.tracer, stream.Context())
+	defer span.Finish()
+
+	ctx := tracer.ContextWithSpan(stream.Context(), span)
+
+	var index, sent uint64
+	for {
+		config, next, err := s.store.WatchConf(ctx, req.Id, req.Version, index, cs.MaxWait)
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if err != nil {
+			return grpcError(err, "Could not watch config")
+		}
+
+		// A wait that times out returns the version unchanged.
+		if config.Index != sent {
+			if err := stream.Send(toConfigPB(config)); err != nil {
+				tracer.LogError(span, err)
+				return err
+			}
+			sent = config.Index
+		}
+
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+func (s *groupService) CreateGroup(ctx context.Context, req *configpb.CreateGroupRequest) (*configpb.Group, error) {
+	span := tracer.StartSpanFromIncomingContext("CreateGroup", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	group := fromGroupPB("", req.Version, req.State, req.Configs)
+	if req.Version == "" || len(group.Configs) == 0 || !validGroupConfigs(group.Configs) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid request")
+	}
+
+	ctx = s.withRequestId(ctx)
+	group, err := s.store.CreateGroup(ctx, group)
+	if err != nil {
+		group, err = s.replayGroup(ctx, err)
+	}
+	if err != nil {
+		return nil, grpcError(err, "Could not create group")
+	}
+
+	return toGroupPB(group), nil
+}
+
+func (s *groupService) CreateGroupVersion(ctx context.Context, req *configpb.CreateGroupRequest) (*configpb.Group, error) {
+	span := tracer.StartSpanFromIncomingContext("CreateGroupVersion", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	group := fromGroupPB(req.Id, req.Version, req.State, req.Configs)
+	if req.Id == "" || req.Version == "" || len(group.Configs) == 0 || !validGroupConfigs(group.Configs) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid request")
+	}
+
+	ctx = s.withRequestId(ctx)
+	group, err := s.store.UpdateGroupVersion(ctx, group)
+	if err != nil {
+		group, err = s.replayGroup(ctx, err)
+	}
+	if err != nil {
+		return nil, grpcError(err, "Could not create group version")
+	}
+
+	return toGroupPB(group), nil
+}
+
+func (s *groupService) GetGroup(ctx context.Context, req *configpb.GetRequest) (*configpb.Group, error) {
+	span := tracer.StartSpanFromIncomingContext("GetGroup", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	group, err := s.store.FindGroup(ctx, req.Id, req.Version)
+	if err != nil {
+		return nil, grpcError(err, "Could not get group")
+	}
+
+	return toGroupPB(group), nil
+}
+
+func (s *groupService) ListGroupVersions(ctx context.Context, req *configpb.ListVersionsRequest) (*configpb.GroupList, error) {
+	span := tracer.StartSpanFromIncomingContext("ListGroupVersions", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	groups, err := s.store.FindGroupVersions(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err, "Could not list group versions")
+	}
+
+	list := &configpb.GroupList{}
+	for _, group := range groups {
+		list.Groups = append(list.Groups, toGroupPB(group))
+	}
+	return list, nil
+}
+
+func (s *groupService) DeleteGroup(ctx context.Context, req *configpb.DeleteRequest) (*emptypb.Empty, error) {
+	span := tracer.StartSpanFromIncomingContext("DeleteGroup", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	err := s.store.DeleteGroup(ctx, req.Id, req.Version, req.Match)
+	if err != nil {
+		return nil, grpcError(err, "Could not delete group")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *groupService) DeleteGroupVersions(ctx context.Context, req *configpb.DeleteVersionsRequest) (*emptypb.Empty, error) {
+	span := tracer.StartSpanFromIncomingContext("DeleteGroupVersions", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	err := s.store.DeleteGroupVersions(ctx, req.Id, req.Force)
+	if err != nil {
+		return nil, grpcError(err, "Could not delete group versions")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *groupService) QueryConfigs(ctx context.Context, req *configpb.LabelQuery) (*configpb.GroupConfigList, error) {
+	span := tracer.StartSpanFromIncomingContext("QueryConfigs", s.tracer, ctx)
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	configs, err := s.store.FindLabels(ctx, req.Id, req.Version, req.Labels)
+	if err != nil {
+		return nil, grpcError(err, "Could not query group")
+	}
+
+	list := &configpb.GroupConfigList{}
+	for _, config := range configs {
+		list.Configs = append(list.Configs, toGroupConfigPB(config))
+	}
+	return list, nil
+}
+
+func (s *groupService) Watch(req *configpb.WatchRequest, stream configpb.GroupService_WatchServer) error {
+	span := tracer.StartSpanFromIncomingContext("WatchGroup", s.tracer, stream.Context())
+	defer span.Finish()
+
+	ctx := tracer.ContextWithSpan(stream.Context(), span)
+
+	var index, sent uint64
+	for {
+		group, next, err := s.store.WatchGroup(ctx, req.Id, req.Version, index, cs.MaxWait)
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if err != nil {
+			return grpcError(err, "Could not watch group")
+		}
+
+		// A wait that times out returns the version unchanged.
+		if group.Index != sent {
+			if err := stream.Send(toGroupPB(group)); err != nil {
+				tracer.LogError(span, err)
+				return err
+			}
+			sent = group.Index
+		}
+
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// withRequestId returns ctx carrying the idempotency key of a call, see
+// cs.WithRequestId, and sends the key back in the x-idempotency-key header.
+// Calls without a key get a new one, like requests to the REST API.
+func (s *Service) withRequestId(ctx context.Context) context.Context {
+	id := requestId(ctx)
+	if id == "" {
+		id = uuid.New().String()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-idempotency-key", id))
+	return cs.WithRequestId(ctx, id)
+}
+
+// replayedChange returns what the call that first used the idempotency key
+// of ctx created, or nil if no call used the key.
+func (s *Service) replayedChange(ctx context.Context) (*cs.Event, error) {
+	request, err := s.store.FindRequest(ctx, requestId(ctx))
+	if err != nil || request == nil {
+		return nil, err
+	}
+	if len(request.Changes) != 1 || request.Changes[0].Operation != cs.EventCreate {
+		return nil, cs.ErrDuplicate
+	}
+	return request.Changes[0], nil
+}
+
+// replayConfig answers a call that failed with err, because its idempotency
+// key was already used, with the config the call that used it created.
+func (s *Service) replayConfig(ctx context.Context, err error) (*cs.Config, error) {
+	change, ferr := s.replayedChange(ctx)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if change == nil {
+		return nil, err
+	}
+	if change.Resource != cs.ResourceConfig {
+		return nil, cs.ErrDuplicate
+	}
+	return s.store.FindConf(ctx, change.ID, change.Version)
+}
+
+// replayGroup is replayConfig for groups.
+func (s *Service) replayGroup(ctx context.Context, err error) (*cs.Group, error) {
+	change, ferr := s.replayedChange(ctx)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if change == nil {
+		return nil, err
+	}
+	if change.Resource != cs.ResourceGroup {
+		return nil, cs.ErrDuplicate
+	}
+	return s.store.FindGroup(ctx, change.ID, change.Version)
+}
+
+// requestId returns the idempotency key sent with a call, if any.
+func requestId(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-idempotency-key"); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// grpcError maps errors returned by the store to a status code, falling
+// back to Internal with msg for errors the store doesn't classify.
+func grpcError(err error, msg string) error {
+	switch {
+	case errors.Is(err, cs.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, cs.ErrExists), errors.Is(err, cs.ErrDuplicate):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, cs.ErrConflict), errors.Is(err, cs.ErrAborted):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, cs.ErrImmutable), errors.Is(err, cs.ErrPublished), errors.Is(err, cs.ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, cs.ErrInvalidState), errors.Is(err, cs.ErrInvalidOp), errors.Is(err, cs.ErrValidation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, cs.ErrUnavailable):
+		return status.Error(codes.Unavailable, cs.ErrUnavailable.Error())
+	default:
+		return status.Error(codes.Internal, fmt.Sprintf("%s: %v", msg, err))
+	}
+}
+
+// entries keeps an absent map from being stored as null.
+func entries(m map[string]string) cs.Entries {
+	if m == nil {
+		return cs.Entries{}
+	}
+	return cs.Entries(m)
+}
+
+func toConfigPB(config *cs.Config) *configpb.Config {
+	return &configpb.Config{
+		Id:      config.ID,
+		Version: config.Version,
+		Entries: config.Entries,
+		State:   config.State,
+		Index:   config.Index,
+	}
+}
+
+func toGroupConfigPB(config *cs.GroupConfig) *configpb.GroupConfig {
+	return &configpb.GroupConfig{Labels: config.Labels, Entries: config.Entries}
+}
+
+func toGroupPB(group *cs.Group) *configpb.Group {
+	pb := &configpb.Group{
+		Id:      group.ID,
+		Version: group.Version,
+		State:   group.State,
+		Index:   group.Index,
+	}
+	for _, config := range group.Configs {
+		pb.Configs = append(pb.Configs, toGroupConfigPB(config))
+	}
+	return pb
+}
+
+func fromGroupPB(id, ver, state string, configs []*configpb.GroupConfig) *cs.Group {
+	group := &cs.Group{ID: id, Version: ver, State: state}
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		group.Configs = append(group.Configs, &cs.GroupConfig{Labels: config.Labels, Entries: entries(config.Entries)})
+	}
+	return group
+}
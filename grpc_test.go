@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dekeract10/ARS-projekat/configpb"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestConn serves the gRPC API of ts over an in-memory listener and
+// returns a connection to it.
+func newTestConn(t *testing.T, ts *Service) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(ts)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dial := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{cs.ErrNotFound, codes.NotFound},
+		{cs.ErrExists, codes.AlreadyExists},
+		{cs.ErrDuplicate, codes.AlreadyExists},
+		{cs.ErrConflict, codes.Aborted},
+		{cs.ErrAborted, codes.Aborted},
+		{cs.ErrImmutable, codes.FailedPrecondition},
+		{cs.ErrPublished, codes.FailedPrecondition},
+		{cs.ErrPreconditionFailed, codes.FailedPrecondition},
+		{cs.ErrInvalidState, codes.InvalidArgument},
+		{cs.ErrInvalidOp, codes.InvalidArgument},
+		{cs.ErrValidation, codes.InvalidArgument},
+		{cs.ErrUnavailable, codes.Unavailable},
+		{fmt.Errorf("%w: v1", cs.ErrNotFound), codes.NotFound},
+		{errors.New("boom"), codes.Internal},
+	}
+
+	for _, test := range tests {
+		if code := status.Code(grpcError(test.err, "Failed")); code != test.code {
+			t.Errorf("%v: got %v, want %v", test.err, code, test.code)
+		}
+	}
+
+	// The cause of an unavailable store stays in the logs.
+	err := grpcError(fmt.Errorf("%w: dial tcp: refused", cs.ErrUnavailable), "Failed")
+	if msg := status.Convert(err).Message(); msg != cs.ErrUnavailable.Error() {
+		t.Errorf("unavailable store answered %q", msg)
+	}
+}
+
+func TestGRPCCallsAnswerStoreErrors(t *testing.T) {
+	ts, _ := newTestService(t)
+	client := configpb.NewConfigServiceClient(newTestConn(t, ts))
+	ctx := context.Background()
+
+	_, err := client.GetConfig(ctx, &configpb.GetRequest{Id: "missing", Version: "v1"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("getting a missing config: got %v, want NotFound", code)
+	}
+
+	_, err = client.CreateConfig(ctx, &configpb.CreateConfigRequest{})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("creating a config without a version: got %v, want InvalidArgument", code)
+	}
+}
+
+func TestGRPCIdempotencyKeyIsReplayed(t *testing.T) {
+	ts, _ := newTestService(t)
+	client := configpb.NewConfigServiceClient(newTestConn(t, ts))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-idempotency-key", "key-1")
+	req := &configpb.CreateConfigRequest{Version: "v1", Entries: map[string]string{"k": "v"}}
+
+	var header metadata.MD
+	first, err := client.CreateConfig(ctx, req, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := header.Get("x-idempotency-key"); len(keys) != 1 || keys[0] != "key-1" {
+		t.Errorf("x-idempotency-key header: %v", keys)
+	}
+
+	again, err := client.CreateConfig(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != first.Id || again.Index != first.Index {
+		t.Errorf("replay returned %s@%d, want %s@%d", again.Id, again.Index, first.Id, first.Index)
+	}
+
+	// A call without a key is given one.
+	header = nil
+	other, err := client.CreateConfig(context.Background(), req, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Id == first.Id {
+		t.Error("a call without a key replayed another call")
+	}
+	if keys := header.Get("x-idempotency-key"); len(keys) != 1 || keys[0] == "" {
+		t.Errorf("x-idempotency-key header of a call without a key: %v", keys)
+	}
+}
+
+func TestGRPCWatchEndsWhenDeleted(t *testing.T) {
+	ts, _ := newTestService(t)
+	client := configpb.NewConfigServiceClient(newTestConn(t, ts))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	config, err := client.CreateConfig(ctx, &configpb.CreateConfigRequest{Version: "v1", State: cs.Draft})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.Watch(ctx, &configpb.WatchRequest{Id: config.Id, Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Index != config.Index {
+		t.Errorf("first watched index %d, want %d", got.Index, config.Index)
+	}
+
+	if _, err := client.DeleteConfig(ctx, &configpb.DeleteRequest{Id: config.Id, Version: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		_, err := stream.Recv()
+		if err == nil {
+			continue
+		}
+		if code := status.Code(err); code != codes.NotFound {
+			t.Errorf("watch ended with %v, want NotFound", err)
+		}
+		break
+	}
+}
@@ -2,44 +2,348 @@ package main
 
 //komentar
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/format"
 	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/google/uuid"
 )
 
-func decodeConfigBody(ctx context.Context, r io.Reader) (*cs.Config, error) {
+// inputFormat returns the format of a request body with the given media type.
+func inputFormat(mediatype string) (string, error) {
+	f, err := format.FromMediaType(mediatype)
+	if err != nil || (f != format.JSON && f != format.YAML && f != format.TOML) {
+		return "", errors.New("Expect application/json, application/yaml or application/toml Content-Type")
+	}
+	return f, nil
+}
+
+func decodeConfigBody(ctx context.Context, r io.Reader, f string) (*cs.Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "decodeConfigBody")
 	defer span.Finish()
 
-	dec := json.NewDecoder(r)
-	dec.DisallowUnknownFields()
-
 	var config *cs.Config
-	if err := dec.Decode(&config); err != nil {
+	if err := format.Decode(r, f, &config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if config == nil {
+		err := errors.New("empty body")
 		tracer.LogError(span, err)
 		return nil, err
 	}
 	return config, nil
 }
 
-func decodeGroupBody(ctx context.Context, r io.Reader) (*cs.Group, error) {
+func decodeGroupBody(ctx context.Context, r io.Reader, f string) (*cs.Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "decodeGroupBody")
 	defer span.Finish()
 
+	var group *cs.Group
+	if err := format.Decode(r, f, &group); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if group == nil {
+		err := errors.New("empty body")
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return group, nil
+}
+
+// decodeGroupConfigsBody reads the members to add to a group. TOML documents
+// can't be lists, so there they are given as the configs array of tables.
+func decodeGroupConfigsBody(ctx context.Context, r io.Reader, f string) ([]*cs.GroupConfig, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeGroupConfigsBody")
+	defer span.Finish()
+
+	var configs []*cs.GroupConfig
+	if f == format.TOML {
+		var body struct {
+			Configs []*cs.GroupConfig `json:"configs"`
+		}
+		if err := format.Decode(r, f, &body); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		configs = body.Configs
+	} else if err := format.Decode(r, f, &configs); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if configs == nil {
+		err := errors.New("empty body")
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return configs, nil
+}
+
+func decodeStateBody(ctx context.Context, r io.Reader) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeStateBody")
+	defer span.Finish()
+
 	dec := json.NewDecoder(r)
 	dec.DisallowUnknownFields()
 
-	var group *cs.Group
-	if err := dec.Decode(&group); err != nil {
+	var body struct {
+		State string `json:"state"`
+	}
+	if err := dec.Decode(&body); err != nil {
+		tracer.LogError(span, err)
+		return "", err
+	}
+	return body.State, nil
+}
+
+// applyPatch applies a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902)
+// to config entries and returns the patched entries. A patch that can be
+// read but not applied fails with cs.ErrValidation.
+func applyPatch(ctx context.Context, mediatype string, entries cs.Entries, patch []byte) (cs.Entries, error) {
+	span := tracer.StartSpanFromContext(ctx, "applyPatch")
+	defer span.Finish()
+
+	// Merge patches address entries as if they were nested, which the
+	// store knows how to do on flat entries.
+	if mediatype == "application/merge-patch+json" {
+		patched, err := entries.MergePatch(patch)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		return patched, nil
+	}
+	if mediatype != "application/json-patch+json" {
+		return nil, errors.New("Expect application/merge-patch+json or application/json-patch+json Content-Type")
+	}
+
+	p, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
-	return group, nil
+
+	doc, err := json.Marshal(entries)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	doc, err = p.Apply(doc)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, fmt.Errorf("%w: %v", cs.ErrValidation, err)
+	}
+
+	var patched cs.Entries
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		tracer.LogError(span, err)
+		return nil, fmt.Errorf("%w: %v", cs.ErrValidation, err)
+	}
+	if patched == nil {
+		err = fmt.Errorf("%w: patched entries must be an object", cs.ErrValidation)
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return patched, nil
+}
+
+// validGroupConfigs reports whether every group member carries both labels,
+// which are used to index it, and entries.
+func validGroupConfigs(configs []*cs.GroupConfig) bool {
+	for _, config := range configs {
+		if config == nil || len(config.Labels) == 0 || config.Entries == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeEntries merges the entries of group members into one set. Members
+// may repeat a key with the same value, but not set it to different values,
+// as one of them would be lost.
+func mergeEntries(configs []*cs.GroupConfig) (cs.Entries, error) {
+	merged := make(cs.Entries)
+	for _, config := range configs {
+		for k, v := range config.Entries {
+			if prev, ok := merged[k]; ok && prev != v {
+				return nil, fmt.Errorf("Configs set %q to different values, request them as JSON", k)
+			}
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// forceParam reports whether the request asks to force an operation, either
+// with a bare ?force or with ?force=<bool>.
+func forceParam(r *http.Request) bool {
+	values, ok := r.URL.Query()["force"]
+	if !ok {
+		return false
+	}
+	if values[0] == "" {
+		return true
+	}
+
+	force, err := strconv.ParseBool(values[0])
+	return err == nil && force
+}
+
+// watchParams returns the index and wait time of a blocking read given as
+// ?index=N&wait=30s. Without an index the read doesn't block, and without a
+// wait it blocks for as long as the store allows.
+func watchParams(r *http.Request) (uint64, time.Duration, error) {
+	query := r.URL.Query()
+
+	var index uint64
+	if value := query.Get("index"); value != "" {
+		i, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("Invalid index")
+		}
+		index = i
+	}
+
+	wait := cs.MaxWait
+	if value := query.Get("wait"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, 0, errors.New("Invalid wait")
+		}
+		if d < wait {
+			wait = d
+		}
+	}
+
+	return index, wait, nil
+}
+
+// setIndex echoes the index to pass to the next blocking read.
+func setIndex(w http.ResponseWriter, index uint64) {
+	w.Header().Set("X-Store-Index", strconv.FormatUint(index, 10))
+}
+
+// etag formats a store index as a strong entity tag.
+func etag(index uint64) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(index, 10))
+}
+
+// representationTag returns the entity tag of the entries of a version at
+// index rendered in format f. Every format, and the nested shape, is a
+// different representation and so gets its own tag; flat JSON, which is
+// what writes answer with, keeps the plain tag of the index.
+func representationTag(r *http.Request, index uint64, f string) string {
+	if r.URL.Query().Get("shape") == "nested" && f != format.Dotenv && f != format.Properties {
+		f += "+nested"
+	}
+	if f == format.JSON {
+		return etag(index)
+	}
+	return fmt.Sprintf("%q", fmt.Sprintf("%d-%s", index, f))
+}
+
+// tagIndex returns the store index a strong entity tag was made from,
+// whatever representation it tags.
+func tagIndex(tag string) (uint64, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	value := tag[1 : len(tag)-1]
+	if i := strings.IndexByte(value, '-'); i >= 0 {
+		value = value[:i]
+	}
+
+	index, err := strconv.ParseUint(value, 10, 64)
+	return index, err == nil
+}
+
+// noneMatch reports whether the If-None-Match header of r matches tag.
+func noneMatch(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns the store index a write has to find a version at for the
+// If-Match header of r to hold, or 0 when the header is missing. current
+// returns the index the version is at now. The header holds when it is "*"
+// or lists an entity tag of that index, in any format; when it doesn't, or
+// there is no such version, ifMatch fails with cs.ErrPreconditionFailed. The
+// write still checks the index, in case the version changes in between.
+func ifMatch(r *http.Request, current func() (uint64, error)) (uint64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, nil
+	}
+
+	index, err := current()
+	if errors.Is(err, cs.ErrNotFound) {
+		return 0, cs.ErrPreconditionFailed
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// If-Match compares strongly, so weak tags never match.
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return index, nil
+		}
+		if i, ok := tagIndex(tag); ok && i == index {
+			return index, nil
+		}
+	}
+	return 0, cs.ErrPreconditionFailed
+}
+
+// configIndex and groupIndex return the current index of a version, for
+// ifMatch.
+func (ts *Service) configIndex(ctx context.Context, id, ver string) func() (uint64, error) {
+	return func() (uint64, error) {
+		config, err := ts.store.FindConf(ctx, id, ver)
+		if err != nil {
+			return 0, err
+		}
+		return config.Index, nil
+	}
+}
+
+func (ts *Service) groupIndex(ctx context.Context, id, ver string) func() (uint64, error) {
+	return func() (uint64, error) {
+		group, err := ts.store.FindGroup(ctx, id, ver)
+		if err != nil {
+			return 0, err
+		}
+		return group.Index, nil
+	}
 }
 
 func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}, id string) {
@@ -49,13 +353,149 @@ func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}, id st
 	js, err := json.Marshal(v)
 	if err != nil {
 		tracer.LogError(span, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProblem(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+}
+
+// created is the body of a successful write: what was stored and the
+// idempotency key the write was recorded under.
+type created struct {
+	Resource       interface{} `json:"resource"`
+	IdempotencyKey string      `json:"idempotencyKey"`
+}
+
+// renderCreated answers a write with 201 Created, pointing the Location
+// header at the stored resource.
+func renderCreated(ctx context.Context, w http.ResponseWriter, location string, v interface{}, reqId string) {
+	span := tracer.StartSpanFromContext(ctx, "renderCreated")
+	defer span.Finish()
+
+	js, err := json.Marshal(&created{Resource: v, IdempotencyKey: reqId})
+	if err != nil {
+		tracer.LogError(span, err)
+		writeProblem(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
 	w.Write(js)
+}
+
+// configLocation and groupLocation return the paths a version is served at.
+func configLocation(id, ver string) string {
+	return fmt.Sprintf("/config/%s/%s", url.PathEscape(id), url.PathEscape(ver))
+}
+
+func groupLocation(id, ver string) string {
+	return fmt.Sprintf("/group/%s/%s/", url.PathEscape(id), url.PathEscape(ver))
+}
+
+// reservedParams are query parameters that are never treated as labels.
+var reservedParams = map[string]bool{
+	"format": true,
+	"shape":  true,
+	"wait":   true,
+	"index":  true,
+}
+
+// labelQuery returns the labels selected by the query parameters of r.
+func labelQuery(r *http.Request) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if reservedParams[k] {
+			continue
+		}
+		labels[k] = v[0]
+	}
+	return labels
+}
+
+// shapeEntries returns entries the way they should be rendered in format f.
+// With ?shape=nested they are reassembled into a tree, except for formats
+// that can only hold flat entries.
+func shapeEntries(r *http.Request, entries cs.Entries, f string) (interface{}, error) {
+	if r.URL.Query().Get("shape") != "nested" || f == format.Dotenv || f == format.Properties {
+		return map[string]string(entries), nil
+	}
+	return entries.Nested()
+}
+
+// negotiateFormat picks the output format from the format query parameter or,
+// failing that, from the Accept header. JSON is used when the client accepts
+// anything.
+func negotiateFormat(r *http.Request) (string, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return format.FromName(name)
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return format.JSON, nil
+	}
+
+	type candidate struct {
+		mediatype string
+		q         float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediatype, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		if c.mediatype == "*/*" || c.mediatype == "application/*" {
+			return format.JSON, nil
+		}
+		if f, err := format.FromMediaType(c.mediatype); err == nil {
+			return f, nil
+		}
+	}
+	return "", format.ErrUnknownFormat
+}
+
+func renderFormat(ctx context.Context, w http.ResponseWriter, f string, v interface{}) {
+	span := tracer.StartSpanFromContext(ctx, "renderFormat")
+	defer span.Finish()
+
+	var buf bytes.Buffer
+	err := format.Encode(&buf, f, v)
+	if errors.Is(err, format.ErrKeyCollision) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+		return
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		writeProblem(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
 
+	w.Header().Set("Content-Type", format.ContentType(f))
+	w.Write(buf.Bytes())
 }
 
 func createId(ctx context.Context) string {
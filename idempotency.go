@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+)
+
+// idempotencyKey returns the idempotency key a write was sent with. Writes
+// sent without one get a new key, which they are answered with so that they
+// can be retried under it.
+func idempotencyKey(r *http.Request) string {
+	if key := r.Header.Get("x-idempotency-key"); key != "" {
+		return key
+	}
+	return uuid.New().String()
+}
+
+// errNotReplayable is returned for requests whose response can't be told
+// again, because what they created is gone.
+var errNotReplayable = errors.New("Request can't be replayed")
+
+// replayRequest answers a write whose idempotency key was already used with
+// what the write that used it first created, as it is now, and reports
+// whether it answered. It doesn't when no write used the key, so that the
+// caller answers with the error the write failed with.
+func (ts *Service) replayRequest(ctx context.Context, w http.ResponseWriter, key string) bool {
+	span := tracer.StartSpanFromContext(ctx, "replayRequest")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	request, err := ts.store.FindRequest(childCtx, key)
+	if err != nil {
+		writeStoreError(w, err, "Could not find request")
+		return true
+	}
+	if request == nil {
+		return false
+	}
+
+	location, v, index, err := ts.replayed(childCtx, request)
+	if err != nil {
+		tracer.LogError(span, err)
+		writeProblem(w, http.StatusConflict, codeDuplicate, "Request has been already sent")
+		return true
+	}
+
+	if index != 0 {
+		w.Header().Set("ETag", etag(index))
+	}
+	renderCreated(ctx, w, location, v, key)
+	return true
+}
+
+// replayed returns the location and the resource a write that made the
+// changes of request answered with, and the index of the version it wrote
+// if it wrote one.
+func (ts *Service) replayed(ctx context.Context, request *cs.Request) (string, interface{}, uint64, error) {
+	if len(request.Changes) != 1 {
+		return "", nil, 0, errNotReplayable
+	}
+
+	change := request.Changes[0]
+	switch {
+	case change.Resource == cs.ResourceConfig && change.Operation == cs.EventCreate:
+		config, err := ts.store.FindConf(ctx, change.ID, change.Version)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return configLocation(config.ID, config.Version), config, config.Index, nil
+
+	case change.Resource == cs.ResourceGroup && change.Operation == cs.EventCreate:
+		group, err := ts.store.FindGroup(ctx, change.ID, change.Version)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return groupLocation(group.ID, group.Version), group, group.Index, nil
+
+	// Adding members is the only update sent with an idempotency key.
+	case change.Resource == cs.ResourceGroup && change.Operation == cs.EventUpdate:
+		group, err := ts.store.FindGroup(ctx, change.ID, change.Version)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return groupLocation(group.ID, group.Version) + "config/", group.Configs, group.Index, nil
+
+	case change.Resource == cs.ResourceWebhook:
+		hook, err := ts.store.FindWebhook(ctx, change.ID)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return webhookLocation(hook.ID), hook, 0, nil
+
+	case change.Resource == snapshotResource:
+		snapshot, err := ts.snapshots.find(change.ID)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return snapshotLocation(snapshot.Name), snapshot, 0, nil
+	}
+
+	return "", nil, 0, errNotReplayable
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,7 +14,14 @@ import (
 )
 
 func main() {
-	quit := make(chan os.Signal)
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := snapshotCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	router := mux.NewRouter()
@@ -27,26 +35,64 @@ func main() {
 
 	router.HandleFunc("/config/", countPostConfig(server.createConfigHandler)).Methods("POST")
 	router.HandleFunc("/config/{id}/", countGetConfigVer(server.getConfigVersionsHandler)).Methods("GET")
+	router.HandleFunc("/config/{id}/", countDelConfigVer(server.delConfigVersionsHandler)).Methods("DELETE")
 	router.HandleFunc("/config/{id}", countPostConfigVer(server.putNewVersion)).Methods("POST")
 	router.HandleFunc("/config/{id}/{ver}", countGetConfig(server.getConfigHandler)).Methods("GET")
 	router.HandleFunc("/config/{id}/{ver}", countDelConfig(server.delConfigHandler)).Methods("DELETE")
+	router.HandleFunc("/config/{id}/{ver}", countPutConfig(server.putConfigHandler)).Methods("PUT")
+	router.HandleFunc("/config/{id}/{ver}", countPatchConfig(server.patchConfigHandler)).Methods("PATCH")
+	router.HandleFunc("/config/{id}/{ver}/state", countConfigState(server.setConfigStateHandler)).Methods("PUT")
+	router.HandleFunc("/config/{id}/{ver}/restore", countRestoreConfig(server.restoreConfigHandler)).Methods("POST")
 	// router.HandleFunc("/config/{id}/{ver}", server.getConfigHandler).Methods("DELETE")
 	// router.HandleFunc("/config/{id}/", server.getAllConfigsHandler).Methods("GET")
 	// router.HandleFunc("/config/{id}/{ver}/", server.getConfigHandler).Methods("GET")
 	// router.HandleFunc("/config/{id}/{ver}/", server.delConfigHandler).Methods("DELETE")
 	router.HandleFunc("/group/", countPostGroup(server.createGroupHandler)).Methods("POST")
 	router.HandleFunc("/group/{id}", countPostGroupVer(server.putNewGroupVersion)).Methods("POST")
+	router.HandleFunc("/group/{id}/", countDelGroupVer(server.delGroupVersionsHandler)).Methods("DELETE")
 	// router.HandleFunc("/group/{id}/", server.getGroupVersionsHandler).Methods("GET")
 	router.HandleFunc("/group/{id}/{ver}/", countGetGroup(server.getGroupHandler)).Methods("GET")
 	// router.HandleFunc("/group/{id}/{ver}/", server.getLabelsHandler).Methods("GET")
 	router.HandleFunc("/group/{id}/{ver}/", countDelGroup(server.delGroupHandler)).Methods("DELETE")
+	router.HandleFunc("/group/{id}/{ver}/state", countGroupState(server.setGroupStateHandler)).Methods("PUT")
+	router.HandleFunc("/group/{id}/{ver}/restore", countRestoreGroup(server.restoreGroupHandler)).Methods("POST")
 	router.HandleFunc("/group/{id}/{ver}/config/", countGetGroupConfigs(server.getConfigFromGroup)).Methods("GET")
 	router.HandleFunc("/group/{id}/{ver}/config/", countAddGroupConfig(server.addConfigToGroupHandler)).Methods("POST")
+	router.HandleFunc("/group/{id}/{ver}/config/", countDelGroupConfig(server.delConfigFromGroupHandler)).Methods("DELETE")
+	router.HandleFunc("/events", countEvents(server.eventsHandler)).Methods("GET")
+	router.HandleFunc("/subscribe", countSubscribe(server.subscribeHandler)).Methods("GET")
+	router.HandleFunc("/webhook/", countPostWebhook(server.createWebhookHandler)).Methods("POST")
+	router.HandleFunc("/webhook/", countGetWebhooks(server.getWebhooksHandler)).Methods("GET")
+	router.HandleFunc("/webhook/{id}", countGetWebhook(server.getWebhookHandler)).Methods("GET")
+	router.HandleFunc("/webhook/{id}", countDelWebhook(server.delWebhookHandler)).Methods("DELETE")
+	router.HandleFunc("/webhook/{id}/deliveries", countGetDeliveries(server.getDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/webhook/{id}/dead-letters", countGetDeadLetters(server.getDeadLettersHandler)).Methods("GET")
+	router.HandleFunc("/webhook/{id}/dead-letters/{delivery}/redeliver", countRedeliver(server.redeliverHandler)).Methods("POST")
+	router.HandleFunc("/batch", countBatch(server.batchHandler)).Methods("POST")
+	router.HandleFunc("/admin/export", countExport(server.exportHandler)).Methods("GET")
+	router.HandleFunc("/admin/import", countImport(server.importHandler)).Methods("POST")
+	router.HandleFunc("/admin/snapshot", countCreateSnapshot(server.createSnapshotHandler)).Methods("POST")
+	router.HandleFunc("/admin/snapshot", countGetSnapshots(server.getSnapshotsHandler)).Methods("GET")
+	router.HandleFunc("/admin/snapshot/{name}", countGetSnapshot(server.getSnapshotHandler)).Methods("GET")
+	router.HandleFunc("/admin/snapshot/{name}/restore", countRestoreSnapshot(server.restoreSnapshotHandler)).Methods("POST")
 	router.Path("/metrics").Handler(metricsHandler())
 	// router.HandleFunc("/group/{id}/configs/{ver}/", server.putConfigHandler).Methods("POST")
 
+	server.recordRestoredFrom()
+	go server.purgeTrash(time.Hour)
+	go server.tailOutbox()
+
+	leading, stopLeading := context.WithCancel(context.Background())
+	defer stopLeading()
+	led := make(chan struct{})
+	go func() {
+		server.lead(leading)
+		close(led)
+	}()
+
 	// start server
 	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
+	srv.RegisterOnShutdown(server.events.close)
 	go func() {
 		log.Println("server starting")
 		if err := srv.ListenAndServe(); err != nil {
@@ -56,10 +102,25 @@ func main() {
 		}
 	}()
 
+	grpcServer := newGRPCServer(server)
+	go func() {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("grpc server starting")
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	<-quit
 
 	log.Println("service shutting down ...")
 
+	// Another instance can take over dispatching right away.
+	stopLeading()
+
 	// gracefully stop server
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -67,5 +128,11 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
+	stopGRPC(ctx, grpcServer)
+	select {
+	case <-led:
+	case <-ctx.Done():
+	}
+	server.closeBus()
 	log.Println("server stopped")
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	cs "github.com/dekeract10/ARS-projekat/configstore"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 	"net/http"
 )
 
@@ -93,10 +96,227 @@ var (
 		},
 	)
 
+	delGroupConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_del_group_config_hit_total",
+			Help: "Total number of remove config from a group hits.",
+		},
+	)
+
+	putConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_put_config_hit_total",
+			Help: "Total number of update draft config hits.",
+		},
+	)
+
+	configStateHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_config_state_hit_total",
+			Help: "Total number of config state change hits.",
+		},
+	)
+
+	groupStateHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_group_state_hit_total",
+			Help: "Total number of group state change hits.",
+		},
+	)
+
+	restoreConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_restore_config_hit_total",
+			Help: "Total number of restore deleted config hits.",
+		},
+	)
+
+	restoreGroupHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_restore_group_hit_total",
+			Help: "Total number of restore deleted group hits.",
+		},
+	)
+
+	delConfigVerHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_del_config_ver_hit_total",
+			Help: "Total number of delete all config versions hits.",
+		},
+	)
+
+	delGroupVerHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_del_group_ver_hit_total",
+			Help: "Total number of delete all group versions hits.",
+		},
+	)
+
+	patchConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_patch_config_hit_total",
+			Help: "Total number of patch config into a new version hits.",
+		},
+	)
+
+	exportHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_export_hit_total",
+			Help: "Total number of export store hits.",
+		},
+	)
+
+	importHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_import_hit_total",
+			Help: "Total number of import store hits.",
+		},
+	)
+
+	eventsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_events_hit_total",
+			Help: "Total number of event stream hits.",
+		},
+	)
+
+	subscribeHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_subscribe_hit_total",
+			Help: "Total number of websocket subscribe hits.",
+		},
+	)
+
+	postWebhookHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_post_webhook_hit_total",
+			Help: "Total number of create webhook hits.",
+		},
+	)
+
+	getWebhooksHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_webhooks_hit_total",
+			Help: "Total number of list webhooks hits.",
+		},
+	)
+
+	getWebhookHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_webhook_hit_total",
+			Help: "Total number of get webhook hits.",
+		},
+	)
+
+	delWebhookHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_del_webhook_hit_total",
+			Help: "Total number of delete webhook hits.",
+		},
+	)
+
+	getDeliveriesHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_deliveries_hit_total",
+			Help: "Total number of get webhook deliveries hits.",
+		},
+	)
+
+	getDeadLettersHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_dead_letters_hit_total",
+			Help: "Total number of get webhook dead letters hits.",
+		},
+	)
+
+	redeliverHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_redeliver_hit_total",
+			Help: "Total number of webhook redelivery hits.",
+		},
+	)
+
+	webhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "configstore_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts by outcome.",
+		},
+		[]string{"status"},
+	)
+
+	grpcHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "configstore_grpc_hit_total",
+			Help: "Total number of gRPC calls by method.",
+		},
+		[]string{"method"},
+	)
+
+	busEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "configstore_bus_events_total",
+			Help: "Total number of events published to the message bus by outcome.",
+		},
+		[]string{"status"},
+	)
+
+	batchHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_batch_hit_total",
+			Help: "Total number of batch hits.",
+		},
+	)
+
+	createSnapshotHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_create_snapshot_hit_total",
+			Help: "Total number of create snapshot hits.",
+		},
+	)
+
+	getSnapshotsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_snapshots_hit_total",
+			Help: "Total number of list snapshots hits.",
+		},
+	)
+
+	getSnapshotHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_snapshot_hit_total",
+			Help: "Total number of get snapshot hits.",
+		},
+	)
+
+	restoreSnapshotHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_restore_snapshot_hit_total",
+			Help: "Total number of restore snapshot hits.",
+		},
+	)
+
+	// snapshotRestored holds a single series labelled with the snapshot the
+	// backend was last restored from, valued with the restore time.
+	snapshotRestored = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "configstore_snapshot_restored_info",
+			Help: "Snapshot the store was last restored from, valued with the restore unix time.",
+		},
+		[]string{"snapshot"},
+	)
+
 	metricsList = []prometheus.Collector{
 		postConfigHits, getConfigVerHits, postConfigVerHits, getConfigHits,
 		delConfigHits, postGroupHits, postGroupVerHits, getGroupHits, delGroupHits,
-		getGroupConfigHits, addGroupConfigHits, httpHits,
+		getGroupConfigHits, addGroupConfigHits, delGroupConfigHits, putConfigHits,
+		configStateHits, groupStateHits, restoreConfigHits, restoreGroupHits,
+		delConfigVerHits, delGroupVerHits, patchConfigHits, exportHits, importHits,
+		batchHits, eventsHits, subscribeHits,
+		postWebhookHits, getWebhooksHits, getWebhookHits, delWebhookHits,
+		getDeliveriesHits, getDeadLettersHits, redeliverHits, webhookDeliveries,
+		busEvents, grpcHits,
+		createSnapshotHits, getSnapshotsHits, getSnapshotHits, restoreSnapshotHits, snapshotRestored,
+		httpHits,
 	}
 
 	prometheusRegistry = prometheus.NewRegistry()
@@ -197,3 +417,218 @@ func countAddGroupConfig(f func(http.ResponseWriter, *http.Request)) func(http.R
 		f(w, r) // original function call
 	}
 }
+
+func countDelGroupConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		delGroupConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPutConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		putConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countConfigState(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		configStateHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGroupState(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		groupStateHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRestoreConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRestoreGroup(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreGroupHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countDelConfigVer(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		delConfigVerHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countDelGroupVer(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		delGroupVerHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPatchConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		patchConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countExport(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		exportHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countImport(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		importHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countEvents(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		eventsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countSubscribe(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		subscribeHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPostWebhook(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		postWebhookHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetWebhooks(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getWebhooksHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetWebhook(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getWebhookHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countDelWebhook(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		delWebhookHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetDeliveries(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getDeliveriesHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetDeadLetters(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getDeadLettersHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRedeliver(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		redeliverHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countBatch(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		batchHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countCreateSnapshot(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		createSnapshotHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetSnapshots(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getSnapshotsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetSnapshot(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getSnapshotHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRestoreSnapshot(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreSnapshotHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func setRestoredFrom(marker *cs.RestoreMarker) {
+	snapshotRestored.Reset()
+	snapshotRestored.WithLabelValues(marker.Snapshot).Set(float64(marker.RestoredAt.Unix()))
+}
+
+func countGRPCUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpcHits.WithLabelValues(info.FullMethod).Inc()
+	return handler(ctx, req) // original function call
+}
+
+func countGRPCStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	grpcHits.WithLabelValues(info.FullMethod).Inc()
+	return handler(srv, ss) // original function call
+}
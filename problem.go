@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+// problemType prefixes the code of a problem to form its type URI.
+const problemType = "urn:configstore:problem:"
+
+// Codes of problems raised by the API itself rather than by the store. Like
+// those of the store, see cs.Code, they never change once published.
+const (
+	codeMalformed        = "malformed_request"
+	codeUnsupportedMedia = "unsupported_media_type"
+	codeNotAcceptable    = "not_acceptable"
+	codeTooLarge         = "request_too_large"
+	codeInternal         = "internal"
+)
+
+// Store codes that handlers raise themselves.
+var (
+	codeNotFound   = cs.Code(cs.ErrNotFound)
+	codeValidation = cs.Code(cs.ErrValidation)
+	codeDuplicate  = cs.Code(cs.ErrDuplicate)
+)
+
+// storeStatuses are the response statuses of the store's error codes.
+var storeStatuses = map[string]int{
+	cs.Code(cs.ErrNotFound):           http.StatusNotFound,
+	cs.Code(cs.ErrConflict):           http.StatusConflict,
+	cs.Code(cs.ErrExists):             http.StatusConflict,
+	cs.Code(cs.ErrDuplicate):          http.StatusConflict,
+	cs.Code(cs.ErrImmutable):          http.StatusConflict,
+	cs.Code(cs.ErrPublished):          http.StatusConflict,
+	cs.Code(cs.ErrPreconditionFailed): http.StatusPreconditionFailed,
+	cs.Code(cs.ErrInvalidState):       http.StatusUnprocessableEntity,
+	cs.Code(cs.ErrInvalidOp):          http.StatusUnprocessableEntity,
+	cs.Code(cs.ErrValidation):         http.StatusUnprocessableEntity,
+	cs.Code(cs.ErrAborted):            http.StatusConflict,
+	cs.Code(cs.ErrUnavailable):        http.StatusServiceUnavailable,
+}
+
+// problem is an RFC 7807 problem details object. Code is the machine-readable
+// name of the problem, the last segment of Type.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// writeProblem answers with an application/problem+json body.
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	js, err := json.Marshal(&problem{
+		Type:   problemType + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// writeStoreError answers with the problem an error returned by the store
+// stands for. Errors the store doesn't classify are internal, and msg is
+// shown in their place. Consul failures aren't shown either, as they name
+// the backend.
+func writeStoreError(w http.ResponseWriter, err error, msg string) {
+	status, code := storeStatus(err)
+	writeProblem(w, status, code, storeDetail(err, msg))
+}
+
+// storeDetail returns the detail of the problem an error returned by the
+// store stands for, see writeStoreError.
+func storeDetail(err error, msg string) string {
+	_, code := storeStatus(err)
+	switch {
+	case code == codeInternal:
+		return msg
+	case errors.Is(err, cs.ErrUnavailable):
+		return cs.ErrUnavailable.Error()
+	default:
+		return err.Error()
+	}
+}
+
+// storeStatus returns the response status and code of an error returned by
+// the store.
+func storeStatus(err error) (int, string) {
+	code := cs.Code(err)
+	status, ok := storeStatuses[code]
+	if !ok {
+		return http.StatusInternalServerError, codeInternal
+	}
+	return status, code
+}
+
+// writeBodyError answers a request whose body couldn't be read, or was read
+// but wraps cs.ErrValidation.
+func writeBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, cs.ErrValidation) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+		return
+	}
+	writeProblem(w, http.StatusBadRequest, codeMalformed, "Invalid request body: "+err.Error())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestStoreErrorProblems(t *testing.T) {
+	// The codes are spelled out, as clients depend on them never changing.
+	tests := []struct {
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{cs.ErrNotFound, http.StatusNotFound, "not_found", cs.ErrNotFound.Error()},
+		{cs.ErrConflict, http.StatusConflict, "conflict", cs.ErrConflict.Error()},
+		{cs.ErrExists, http.StatusConflict, "already_exists", cs.ErrExists.Error()},
+		{cs.ErrDuplicate, http.StatusConflict, "duplicate_request", cs.ErrDuplicate.Error()},
+		{cs.ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed", cs.ErrPreconditionFailed.Error()},
+		{cs.ErrImmutable, http.StatusConflict, "immutable", cs.ErrImmutable.Error()},
+		{cs.ErrInvalidState, http.StatusUnprocessableEntity, "invalid_state", cs.ErrInvalidState.Error()},
+		{cs.ErrPublished, http.StatusConflict, "published", cs.ErrPublished.Error()},
+		{cs.ErrInvalidOp, http.StatusUnprocessableEntity, "invalid_operation", cs.ErrInvalidOp.Error()},
+		{cs.ErrAborted, http.StatusConflict, "aborted", cs.ErrAborted.Error()},
+		{cs.ErrValidation, http.StatusUnprocessableEntity, "validation_failed", cs.ErrValidation.Error()},
+		{cs.ErrUnavailable, http.StatusServiceUnavailable, "unavailable", cs.ErrUnavailable.Error()},
+		{fmt.Errorf("%w: \"v1\"", cs.ErrNotFound), http.StatusNotFound, "not_found", cs.ErrNotFound.Error() + ": \"v1\""},
+		{fmt.Errorf("%w: dial tcp: refused", cs.ErrUnavailable), http.StatusServiceUnavailable, "unavailable", cs.ErrUnavailable.Error()},
+		{errors.New("boom"), http.StatusInternalServerError, "internal", "Could not do it"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		writeStoreError(w, test.err, "Could not do it")
+
+		if w.Code != test.status {
+			t.Errorf("%v: status %d, want %d", test.err, w.Code, test.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%v: content type %q", test.err, ct)
+		}
+
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Errorf("%v: %v", test.err, err)
+			continue
+		}
+		want := problem{
+			Type:   "urn:configstore:problem:" + test.code,
+			Title:  http.StatusText(test.status),
+			Status: test.status,
+			Detail: test.detail,
+			Code:   test.code,
+		}
+		if p != want {
+			t.Errorf("%v: got %+v, want %+v", test.err, p, want)
+		}
+	}
+}
+
+func TestEveryStoreErrorHasAStatus(t *testing.T) {
+	for _, err := range []error{
+		cs.ErrNotFound, cs.ErrConflict, cs.ErrExists, cs.ErrDuplicate,
+		cs.ErrPreconditionFailed, cs.ErrImmutable, cs.ErrInvalidState,
+		cs.ErrPublished, cs.ErrInvalidOp, cs.ErrAborted, cs.ErrValidation,
+		cs.ErrUnavailable,
+	} {
+		code := cs.Code(err)
+		if code == "" {
+			t.Errorf("%v has no code", err)
+		}
+		if cs.FromCode(code) != err {
+			t.Errorf("code %q reads back as %v, want %v", code, cs.FromCode(code), err)
+		}
+		if _, ok := storeStatuses[code]; !ok {
+			t.Errorf("code %q has no status", code)
+		}
+	}
+}
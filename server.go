@@ -2,28 +2,42 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
-	"net/url"
+	"time"
 
 	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/eventbus"
+	"github.com/dekeract10/ARS-projekat/format"
 	tracer "github.com/dekeract10/ARS-projekat/tracer"
 	"github.com/gorilla/mux"
+	"github.com/hashicorp/consul/api"
 	"github.com/opentracing/opentracing-go"
 )
 
 type Service struct {
-	store  *cs.ConfigStore
-	tracer opentracing.Tracer
-	closer io.Closer
+	store     *cs.ConfigStore
+	snapshots *snapshotDir
+	events    *eventHub
+	webhooks  *webhookDispatcher
+	bus       eventbus.Publisher
+	tracer    opentracing.Tracer
+	closer    io.Closer
 }
 
 const (
 	name = "configstore"
+
+	dispatchBackoff    = time.Second
+	dispatchMaxBackoff = time.Minute
+
+	// maxPatchSize bounds the body of a patch. The patched version is
+	// written as a single value, which the store takes up to 512 KiB of.
+	maxPatchSize = 512 << 10
 )
 
 func NewConfigServer() (*Service, error) {
@@ -32,15 +46,168 @@ func NewConfigServer() (*Service, error) {
 		return nil, err
 	}
 
+	snapshots, err := newSnapshotDir()
+	if err != nil {
+		return nil, err
+	}
+
+	events := newEventHub()
+
+	webhooks := newWebhookDispatcher(store)
+	store.OnEvent("webhooks", webhooks.dispatch)
+
+	bus, err := eventbus.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if bus != nil {
+		store.OnEvent("eventbus", func(event *cs.Event) error {
+			if err := bus.Publish(event); err != nil {
+				log.Printf("publishing event %d: %v", event.Seq, err)
+				busEvents.WithLabelValues("failed").Inc()
+				return err
+			}
+			busEvents.WithLabelValues("published").Inc()
+			return nil
+		})
+	}
+
 	tracer, closer := tracer.Init(name)
 	opentracing.SetGlobalTracer(tracer)
 	return &Service{
-		store:  store,
-		tracer: tracer,
-		closer: closer,
+		store:     store,
+		snapshots: snapshots,
+		events:    events,
+		webhooks:  webhooks,
+		bus:       bus,
+		tracer:    tracer,
+		closer:    closer,
 	}, nil
 }
 
+// purgeTrash periodically removes trashed records whose retention period
+// has passed.
+func (s *Service) purgeTrash(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.store.PurgeTrash(context.Background())
+		if err != nil {
+			log.Printf("purging trash: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("purged %d expired trash records", n)
+		}
+	}
+}
+
+// lead dispatches the outbox and sends webhook deliveries while this
+// instance holds the dispatcher lock, and waits for the lock again whenever
+// it is lost, until ctx is done. Of several instances only one leads.
+func (s *Service) lead(ctx context.Context) {
+	for ctx.Err() == nil {
+		lost, release, err := s.store.Lead(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("acquiring dispatcher lock: %v", err)
+				time.Sleep(dispatchBackoff)
+			}
+			continue
+		}
+
+		log.Println("leading outbox dispatch")
+		leading, stop := context.WithCancel(ctx)
+		go s.dispatchOutbox(leading)
+		go s.webhooks.run(leading)
+
+		select {
+		case <-lost:
+			log.Println("lost dispatcher lock")
+		case <-ctx.Done():
+		}
+		stop()
+
+		if err := release(); err != nil && err != api.ErrLockNotHeld {
+			log.Printf("releasing dispatcher lock: %v", err)
+		}
+	}
+}
+
+// dispatchOutbox keeps passing the events committed to the store on to its
+// listeners until ctx is done. Events a listener didn't take are dispatched
+// again with exponential backoff.
+func (s *Service) dispatchOutbox(ctx context.Context) {
+	var index uint64
+	backoff := dispatchBackoff
+	for ctx.Err() == nil {
+		next, err := s.store.DispatchOutbox(ctx, index, cs.MaxWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("dispatching outbox: %v", err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > dispatchMaxBackoff {
+				backoff = dispatchMaxBackoff
+			}
+			index = 0
+			continue
+		}
+		backoff = dispatchBackoff
+
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// tailOutbox publishes the events committed to the store on the event hub
+// of this instance, whichever instance dispatches them. Events pruned from
+// the outbox before the tail read them are missed by the hub, including
+// those before it started.
+func (s *Service) tailOutbox() {
+	var index, seq uint64
+	for {
+		events, pruned, next, err := s.store.TailOutbox(context.Background(), seq, index, cs.MaxWait)
+		if err != nil {
+			log.Printf("tailing outbox: %v", err)
+			time.Sleep(time.Second)
+			index = 0
+			continue
+		}
+
+		if pruned > seq {
+			s.events.miss(pruned)
+		}
+
+		for _, event := range events {
+			s.events.publish(event)
+			seq = event.Seq
+		}
+
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// closeBus flushes the events not yet sent to the message bus, if any.
+func (s *Service) closeBus() {
+	if s.bus == nil {
+		return
+	}
+	if err := s.bus.Close(); err != nil {
+		log.Printf("closing event bus: %v", err)
+	}
+}
+
 func (s *Service) GetTracer() opentracing.Tracer {
 	return s.tracer
 }
@@ -62,42 +229,43 @@ func (ts *Service) createConfigHandler(w http.ResponseWriter, req *http.Request)
 	)
 
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
+	requestId := idempotencyKey(req)
 
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
 		return
 	}
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
-	rt, err := decodeConfigBody(ctx, req.Body)
-	if err != nil || rt.Version == "" || rt.Entries == nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	rt, err := decodeConfigBody(ctx, req.Body, f)
+	if err != nil {
+		writeBodyError(w, err)
 		return
 	}
-
-	if ts.store.FindRequestId(ctx, requestId) == true {
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
+	if rt.Version == "" || rt.Entries == nil {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Version and entries are required")
 		return
 	}
 
-	config, err := ts.store.CreateConfig(ctx, rt)
-
-	reqId := ""
-
-	if err == nil {
-		reqId = ts.store.SaveRequestId(ctx)
+	config, err := ts.store.CreateConfig(cs.WithRequestId(ctx, requestId), rt)
+	if err != nil {
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create config")
+		return
 	}
-	w.Write([]byte(config.ID))
-	w.Write([]byte("\n\nIdempotence key: " + reqId))
+
+	w.Header().Set("ETag", etag(config.Index))
+	renderCreated(ctx, w, configLocation(config.ID, config.Version), config, requestId)
 }
 
 func (ts *Service) putNewVersion(w http.ResponseWriter, req *http.Request) {
@@ -109,55 +277,129 @@ func (ts *Service) putNewVersion(w http.ResponseWriter, req *http.Request) {
 	)
 
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
+	requestId := idempotencyKey(req)
 
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	id := mux.Vars(req)["id"]
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
 		return
 	}
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
-	rt, err := decodeConfigBody(ctx, req.Body)
+	rt, err := decodeConfigBody(ctx, req.Body, f)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
 	rt.ID = id
 
-	if ts.store.FindRequestId(ctx, requestId) == true {
+	config, err := ts.store.UpdateConfigVersion(cs.WithRequestId(ctx, requestId), rt)
+	if err != nil {
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create config version")
+		return
+	}
+
+	w.Header().Set("ETag", etag(config.Index))
+	renderCreated(ctx, w, configLocation(config.ID, config.Version), config, requestId)
+}
+
+// patchConfigHandler applies a patch to the entries of a config version and
+// stores the result as the version named by the X-Config-Version header or
+// the version query parameter.
+func (ts *Service) patchConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("patchConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling patch config at %s\n", req.URL.Path)),
+	)
+
+	contentType := req.Header.Get("Content-Type")
+	requestId := idempotencyKey(req)
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	if mediatype != "application/merge-patch+json" && mediatype != "application/json-patch+json" {
+		err := errors.New("Expect application/merge-patch+json or application/json-patch+json Content-Type")
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
 
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
 
+	newVer := req.Header.Get("X-Config-Version")
+	if newVer == "" {
+		newVer = req.URL.Query().Get("version")
+	}
+	if newVer == "" {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "New version is required")
 		return
 	}
 
-	config, err := ts.store.UpdateConfigVersion(ctx, rt)
+	if req.ContentLength > maxPatchSize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Patch is larger than %d bytes", maxPatchSize))
+		return
+	}
 
+	// The reader fails only once it has passed on the whole limit when the
+	// body goes past it.
+	patch, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPatchSize))
+	if err != nil && len(patch) == maxPatchSize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Patch is larger than %d bytes", maxPatchSize))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Given config version already exists! ", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
 
-	reqId := ""
+	base, err := ts.store.FindConf(ctx, id, ver)
+	if err != nil {
+		writeStoreError(w, err, "Could not find config")
+		return
+	}
 
-	if err == nil {
+	entries, err := applyPatch(ctx, mediatype, base.Entries, patch)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
 
-		reqId = ts.store.SaveRequestId(ctx)
+	config, err := ts.store.UpdateConfigVersion(cs.WithRequestId(ctx, requestId), &cs.Config{
+		ID:      id,
+		Version: newVer,
+		Entries: entries,
+	})
+	if err != nil {
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create config version")
+		return
 	}
 
-	w.Write([]byte(config.ID))
-	w.Write([]byte("\n\nIdempotence key: " + reqId))
+	w.Header().Set("ETag", etag(config.Index))
+	renderCreated(ctx, w, configLocation(config.ID, config.Version), config, requestId)
 }
 
 func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
@@ -168,17 +410,54 @@ func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
 		tracer.LogString("handler", fmt.Sprintf("Handling get config at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	// Blocking reads end early when the client goes away.
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
 	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
-	task, ok := ts.store.FindConf(ctx, id, ver)
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+
+	f, err := negotiateFormat(req)
+	if err != nil {
+		writeProblem(w, http.StatusNotAcceptable, codeNotAcceptable, err.Error())
 		return
 	}
-	renderJSON(ctx, w, task, "")
+
+	index, wait, err := watchParams(req)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	task, index, err := ts.store.WatchConf(ctx, id, ver, index, wait)
+	setIndex(w, index)
+	if err != nil {
+		writeStoreError(w, err, "Could not get config")
+		return
+	}
+
+	// The tag depends on the format, which may be negotiated.
+	tag := representationTag(req, task.Index, f)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", tag)
+	if noneMatch(req, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	entries, err := shapeEntries(req, task.Entries, f)
+	if err != nil {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+		return
+	}
+
+	if f != format.JSON {
+		renderFormat(ctx, w, f, entries)
+		return
+	}
+	renderJSON(ctx, w, struct {
+		*cs.Config
+		Entries interface{} `json:"entries"`
+	}{task, entries}, "")
 }
 
 func (ts *Service) getConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
@@ -192,10 +471,9 @@ func (ts *Service) getConfigVersionsHandler(w http.ResponseWriter, req *http.Req
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
 	id := mux.Vars(req)["id"]
-	task, ok := ts.store.FindConfVersions(ctx, id)
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+	task, err := ts.store.FindConfVersions(ctx, id)
+	if err != nil {
+		writeStoreError(w, err, "Could not get config versions")
 		return
 	}
 	renderJSON(ctx, w, task, "")
@@ -212,44 +490,41 @@ func (ts *Service) createGroupHandler(w http.ResponseWriter, req *http.Request)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
+	requestId := idempotencyKey(req)
 
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
 		return
 	}
 
-	rt, err := decodeGroupBody(ctx, req.Body)
-	if err != nil || rt.Version == "" || rt.Configs == nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	rt, err := decodeGroupBody(ctx, req.Body, f)
+	if err != nil {
+		writeBodyError(w, err)
 		return
 	}
-
-	if ts.store.FindRequestId(ctx, requestId) == true {
-
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
-
+	if rt.Version == "" || rt.Configs == nil || !validGroupConfigs(rt.Configs) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Version and configs are required, and every config needs labels and entries")
 		return
 	}
 
-	group, err := ts.store.CreateGroup(ctx, rt)
-
-	reqId := ""
-
-	if err == nil {
-
-		reqId = ts.store.SaveRequestId(ctx)
+	group, err := ts.store.CreateGroup(cs.WithRequestId(ctx, requestId), rt)
+	if err != nil {
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create group")
+		return
 	}
 
-	w.Write([]byte(group.ID))
-	w.Write([]byte("\n\nIdempotence key: " + reqId))
+	w.Header().Set("ETag", etag(group.Index))
+	renderCreated(ctx, w, groupLocation(group.ID, group.Version), group, requestId)
 }
 
 func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
@@ -260,16 +535,27 @@ func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
 		tracer.LogString("handler", fmt.Sprintf("Handling get group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
 	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
 
-	task, ok := ts.store.FindGroup(ctx, id, ver)
+	index, wait, err := watchParams(req)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	task, index, err := ts.store.WatchGroup(ctx, id, ver, index, wait)
+	setIndex(w, index)
+	if err != nil {
+		writeStoreError(w, err, "Could not get group")
+		return
+	}
 
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+	w.Header().Set("ETag", etag(task.Index))
+	if noneMatch(req, etag(task.Index)) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	renderJSON(ctx, w, task, "")
@@ -283,19 +569,63 @@ func (ts *Service) getConfigFromGroup(w http.ResponseWriter, req *http.Request)
 		tracer.LogString("handler", fmt.Sprintf("Handling get config from group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
 	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
 
-	req.ParseForm()
-	params := url.Values.Encode(req.Form)
-	labels, err := ts.store.FindLabels(ctx, id, ver, params)
+	f, err := negotiateFormat(req)
+	if err != nil {
+		writeProblem(w, http.StatusNotAcceptable, codeNotAcceptable, err.Error())
+		return
+	}
+
+	index, wait, err := watchParams(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
-	renderJSON(ctx, w, labels, "")
+
+	configs, index, err := ts.store.WatchLabels(ctx, id, ver, labelQuery(req), index, wait)
+	setIndex(w, index)
+	if err != nil {
+		writeStoreError(w, err, "Could not get group configs")
+		return
+	}
+
+	if f != format.JSON {
+		// Other formats hold a single set of entries, so the entries of
+		// all matching configs are merged.
+		merged, err := mergeEntries(configs)
+		if err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+			return
+		}
+
+		entries, err := shapeEntries(req, merged, f)
+		if err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+			return
+		}
+		renderFormat(ctx, w, f, entries)
+		return
+	}
+
+	type shapedConfig struct {
+		*cs.GroupConfig
+		Entries interface{} `json:"entries"`
+	}
+
+	shaped := make([]shapedConfig, len(configs))
+	for i, config := range configs {
+		entries, err := shapeEntries(req, config.Entries, f)
+		if err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, codeValidation, err.Error())
+			return
+		}
+		shaped[i] = shapedConfig{config, entries}
+	}
+	renderJSON(ctx, w, shaped, "")
 }
 
 func (ts *Service) putNewGroupVersion(w http.ResponseWriter, req *http.Request) {
@@ -309,52 +639,45 @@ func (ts *Service) putNewGroupVersion(w http.ResponseWriter, req *http.Request)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
+	requestId := idempotencyKey(req)
 
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	id := mux.Vars(req)["id"]
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
 		return
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
 		return
 	}
 
-	rt, err := decodeGroupBody(ctx, req.Body)
-	if err != nil || rt.Version == "" || rt.Configs == nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	rt, err := decodeGroupBody(ctx, req.Body, f)
+	if err != nil {
+		writeBodyError(w, err)
 		return
 	}
-
-	if ts.store.FindRequestId(ctx, requestId) == true {
-
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
-
+	if rt.Version == "" || rt.Configs == nil || !validGroupConfigs(rt.Configs) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Version and configs are required, and every config needs labels and entries")
 		return
 	}
 
 	rt.ID = id
 
-	config, err := ts.store.UpdateGroupVersion(ctx, rt)
-
-	reqId := ""
-
-	if err == nil {
-		reqId = ts.store.SaveRequestId(ctx)
-	}
-
+	config, err := ts.store.UpdateGroupVersion(cs.WithRequestId(ctx, requestId), rt)
 	if err != nil {
-		http.Error(w, "Given config version already exists! ", http.StatusBadRequest)
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create group version")
 		return
 	}
 
-	w.Write([]byte(config.ID))
-	w.Write([]byte("\n\nIdempotence key: " + reqId))
+	w.Header().Set("ETag", etag(config.Index))
+	renderCreated(ctx, w, groupLocation(config.ID, config.Version), config, requestId)
 }
 
 func (ts *Service) delGroupHandler(writer http.ResponseWriter, request *http.Request) {
@@ -369,9 +692,16 @@ func (ts *Service) delGroupHandler(writer http.ResponseWriter, request *http.Req
 
 	id := mux.Vars(request)["id"]
 	ver := mux.Vars(request)["ver"]
-	err := ts.store.DeleteGroup(ctx, id, ver)
+
+	match, err := ifMatch(request, ts.groupIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(writer, err, err.Error())
+		return
+	}
+
+	err = ts.store.DeleteGroup(ctx, id, ver, match)
 	if err != nil {
-		http.Error(writer, "Could not delete group", http.StatusBadRequest)
+		writeStoreError(writer, err, "Could not delete group")
 	}
 }
 
@@ -385,40 +715,84 @@ func (ts *Service) addConfigToGroupHandler(w http.ResponseWriter, r *http.Reques
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
-	requestId := r.Header.Get("x-idempotency-key")
+	requestId := idempotencyKey(r)
 
 	id := mux.Vars(r)["id"]
 	ver := mux.Vars(r)["ver"]
-	var configs []map[string]string
-	dec := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+	match, err := ifMatch(r, ts.groupIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
+
+	mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
 
-	err := dec.Decode(&configs)
+	f, err := inputFormat(mediatype)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
 		return
 	}
 
-	configs, err = ts.store.AddLabelsToGroup(ctx, configs, id, ver)
+	configs, err := decodeGroupConfigsBody(ctx, r.Body, f)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !validGroupConfigs(configs) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Every config needs labels and entries")
+		return
+	}
 
+	configs, index, err := ts.store.AddLabelsToGroup(cs.WithRequestId(ctx, requestId), configs, id, ver, match)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not add configs to group")
 		return
 	}
 
-	if ts.store.FindRequestId(ctx, requestId) == true {
+	w.Header().Set("ETag", etag(index))
+	renderCreated(ctx, w, groupLocation(id, ver)+"config/", configs, requestId)
+}
+
+func (ts *Service) delConfigFromGroupHandler(w http.ResponseWriter, r *http.Request) {
+	span := tracer.StartSpanFromRequest("delConfigFromGroupHandler", ts.tracer, r)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling remove config from group at %s\n", r.URL.Path)),
+	)
 
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
 
+	id := mux.Vars(r)["id"]
+	ver := mux.Vars(r)["ver"]
+
+	labels := labelQuery(r)
+	if len(labels) == 0 {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Label selector is required")
 		return
 	}
 
-	reqId := ts.store.SaveRequestId(ctx)
-
-	w.Write([]byte("Idempotence key: " + reqId))
+	match, err := ifMatch(r, ts.groupIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
 
-	//renderJSON(w, configs, reqId)
+	configs, index, err := ts.store.RemoveConfigsFromGroup(ctx, id, ver, labels, match)
+	if err != nil {
+		writeStoreError(w, err, "Could not remove config from group")
+		return
+	}
 
+	w.Header().Set("ETag", etag(index))
+	renderJSON(ctx, w, configs, "")
 }
 
 func (ts *Service) delConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -433,8 +807,226 @@ func (ts *Service) delConfigHandler(w http.ResponseWriter, r *http.Request) {
 
 	id := mux.Vars(r)["id"]
 	ver := mux.Vars(r)["ver"]
-	_, err := ts.store.DeleteConfig(ctx, id, ver)
+
+	match, err := ifMatch(r, ts.configIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
+
+	_, err = ts.store.DeleteConfig(ctx, id, ver, match)
+	if err != nil {
+		writeStoreError(w, err, "Could not delete config")
+	}
+}
+
+func (ts *Service) putConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("putConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling update draft config at %s\n", req.URL.Path)),
+	)
+
+	contentType := req.Header.Get("Content-Type")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+
+	f, err := inputFormat(mediatype)
+	if err != nil {
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	rt, err := decodeConfigBody(ctx, req.Body, f)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if rt.Entries == nil {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "Entries are required")
+		return
+	}
+
+	rt.ID = mux.Vars(req)["id"]
+	rt.Version = mux.Vars(req)["ver"]
+
+	rt.Index, err = ifMatch(req, ts.configIndex(ctx, rt.ID, rt.Version))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
+
+	config, err := ts.store.UpdateConfig(ctx, rt)
+	if err != nil {
+		writeStoreError(w, err, "Could not update config")
+		return
+	}
+
+	w.Header().Set("ETag", etag(config.Index))
+	renderJSON(ctx, w, config, "")
+}
+
+func (ts *Service) setConfigStateHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("setConfigStateHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling set config state at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	state, err := decodeStateBody(ctx, req.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if state == "" {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "State is required")
+		return
+	}
+
+	match, err := ifMatch(req, ts.configIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
+
+	config, err := ts.store.SetConfigState(ctx, id, ver, state, match)
+	if err != nil {
+		writeStoreError(w, err, "Could not change config state")
+		return
+	}
+
+	w.Header().Set("ETag", etag(config.Index))
+	renderJSON(ctx, w, config, "")
+}
+
+func (ts *Service) setGroupStateHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("setGroupStateHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling set group state at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	state, err := decodeStateBody(ctx, req.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if state == "" {
+		writeProblem(w, http.StatusUnprocessableEntity, codeValidation, "State is required")
+		return
+	}
+
+	match, err := ifMatch(req, ts.groupIndex(ctx, id, ver))
+	if err != nil {
+		writeStoreError(w, err, err.Error())
+		return
+	}
+
+	group, err := ts.store.SetGroupState(ctx, id, ver, state, match)
+	if err != nil {
+		writeStoreError(w, err, "Could not change group state")
+		return
+	}
+
+	w.Header().Set("ETag", etag(group.Index))
+	renderJSON(ctx, w, group, "")
+}
+
+func (ts *Service) restoreConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling restore config at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	config, err := ts.store.RestoreConfig(ctx, id, ver)
+	if err != nil {
+		writeStoreError(w, err, "Could not restore config")
+		return
+	}
+
+	w.Header().Set("ETag", etag(config.Index))
+	renderJSON(ctx, w, config, "")
+}
+
+func (ts *Service) restoreGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling restore group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	group, err := ts.store.RestoreGroup(ctx, id, ver)
+	if err != nil {
+		writeStoreError(w, err, "Could not restore group")
+		return
+	}
+
+	w.Header().Set("ETag", etag(group.Index))
+	renderJSON(ctx, w, group, "")
+}
+
+func (ts *Service) delConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	span := tracer.StartSpanFromRequest("delConfigVersionsHandler", ts.tracer, r)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete all config versions at %s\n", r.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(r)["id"]
+	err := ts.store.DeleteConfigVersions(ctx, id, forceParam(r))
+	if err != nil {
+		writeStoreError(w, err, "Could not delete config")
+	}
+}
+
+func (ts *Service) delGroupVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	span := tracer.StartSpanFromRequest("delGroupVersionsHandler", ts.tracer, r)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete all group versions at %s\n", r.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(r)["id"]
+	err := ts.store.DeleteGroupVersions(ctx, id, forceParam(r))
 	if err != nil {
-		http.Error(w, "Could not delete config", http.StatusBadRequest)
+		writeStoreError(w, err, "Could not delete group")
 	}
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	"github.com/dekeract10/ARS-projekat/configstore/consultest"
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+)
+
+// newTestService returns a service whose store is backed by a fresh
+// consultest.Server.
+func newTestService(t *testing.T) (*Service, *consultest.Server) {
+	t.Helper()
+
+	fake, address := consultest.NewServer()
+	t.Cleanup(fake.Close)
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("DB", host)
+	os.Setenv("DBPORT", port)
+
+	store, err := cs.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Service{
+		store:    store,
+		events:   newEventHub(),
+		webhooks: newWebhookDispatcher(store),
+		tracer:   opentracing.NoopTracer{},
+	}, fake
+}
+
+// serve passes r to handler with the route variables vars, and returns the
+// response.
+func serve(handler http.HandlerFunc, r *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, mux.SetURLVars(r, vars))
+	return w
+}
+
+func TestIfMatch(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	config, err := ts.store.CreateConfig(context.Background(), &cs.Config{Version: "v1", Entries: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": config.ID, "ver": "v1"}
+
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/config/"+config.ID+"/v1", strings.NewReader(`{"entries": {"k": "v"}}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("If-Match", ifMatch)
+		return serve(ts.putConfigHandler, r, vars)
+	}
+
+	stale := etag(config.Index)
+	w := put(stale)
+	if w.Code != http.StatusOK {
+		t.Fatalf("put with the current tag answered %d", w.Code)
+	}
+	current := w.Header().Get("ETag")
+	if current == "" || current == stale {
+		t.Fatalf("put answered ETag %q after %q", current, stale)
+	}
+
+	tests := []struct {
+		ifMatch string
+		status  int
+	}{
+		{stale, http.StatusPreconditionFailed},
+		{"W/" + current, http.StatusPreconditionFailed},
+		{stale + ", " + current, http.StatusOK},
+		{"*", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		w := put(test.ifMatch)
+		if w.Code != test.status {
+			t.Errorf("If-Match %s answered %d, want %d", test.ifMatch, w.Code, test.status)
+		}
+	}
+
+	// * only matches a version that exists.
+	r := httptest.NewRequest(http.MethodDelete, "/config/"+config.ID+"/v2", nil)
+	r.Header.Set("If-Match", "*")
+	w = serve(ts.delConfigHandler, r, map[string]string{"id": config.ID, "ver": "v2"})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete of a missing version with If-Match * answered %d, want 412", w.Code)
+	}
+}
+
+func TestPatchBodyIsBounded(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	config, err := ts.store.CreateConfig(context.Background(), &cs.Config{Version: "v1", Entries: cs.Entries{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": config.ID, "ver": "v1"}
+
+	body := `{"k": "` + strings.Repeat("x", maxPatchSize) + `"}`
+	tests := []struct {
+		name   string
+		length int64
+	}{
+		{"declared", int64(len(body))},
+		{"chunked", -1},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/config/"+config.ID+"/v1?version=v2", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		r.ContentLength = test.length
+
+		w := serve(ts.patchConfigHandler, r, vars)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: patch of %d bytes answered %d, want 413", test.name, len(body), w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/config/"+config.ID+"/v1?version=v2", strings.NewReader(`{"k": "w"}`))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	if w := serve(ts.patchConfigHandler, r, vars); w.Code != http.StatusCreated {
+		t.Errorf("small patch answered %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	config, err := ts.store.CreateConfig(context.Background(), &cs.Config{Version: "v1", Entries: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": config.ID, "ver": "v1"}
+
+	tests := []struct {
+		mediatype string
+		patch     string
+		status    int
+	}{
+		{"application/merge-patch+json", `{"k": `, http.StatusBadRequest},
+		{"application/json-patch+json", `{"op": "remove"}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `[1]`, http.StatusUnprocessableEntity},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/missing"}]`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/config/"+config.ID+"/v1?version=v2", strings.NewReader(test.patch))
+		r.Header.Set("Content-Type", test.mediatype)
+
+		w := serve(ts.patchConfigHandler, r, vars)
+		if w.Code != test.status {
+			t.Errorf("%s %s answered %d, want %d", test.mediatype, test.patch, w.Code, test.status)
+		}
+	}
+}
+
+func TestFormatsAreTaggedSeparately(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	config, err := ts.store.CreateConfig(context.Background(), &cs.Config{Version: "v1", Entries: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": config.ID, "ver": "v1"}
+
+	get := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/config/"+config.ID+"/v1"+query, nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		return serve(ts.getConfigHandler, r, vars)
+	}
+
+	jsonTag := get("", "").Header().Get("ETag")
+	yamlTag := get("?format=yaml", "").Header().Get("ETag")
+	if jsonTag == "" || yamlTag == "" || jsonTag == yamlTag {
+		t.Fatalf("JSON tagged %q and YAML tagged %q", jsonTag, yamlTag)
+	}
+
+	if w := get("?format=yaml", yamlTag); w.Code != http.StatusNotModified {
+		t.Errorf("YAML with its own tag answered %d, want 304", w.Code)
+	}
+	if w := get("?format=yaml", jsonTag); w.Code != http.StatusOK {
+		t.Errorf("YAML with the JSON tag answered %d, want 200", w.Code)
+	}
+
+	// Writes accept the tag of any format of the current version.
+	r := httptest.NewRequest(http.MethodPut, "/config/"+config.ID+"/v1", strings.NewReader(`{"entries": {"k": "w"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("If-Match", yamlTag)
+	if w := serve(ts.putConfigHandler, r, vars); w.Code != http.StatusOK {
+		t.Errorf("put with the YAML tag answered %d, want 200", w.Code)
+	}
+}
+
+func TestMergedMembersMustAgree(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	labels := map[string]string{"env": "prod"}
+	group, err := ts.store.CreateGroup(context.Background(), &cs.Group{Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: labels, Entries: map[string]string{"k": "a"}},
+		{Labels: labels, Entries: map[string]string{"k": "b"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/group/"+group.ID+"/v1/config?env=prod&format=dotenv", nil)
+	w := serve(ts.getConfigFromGroup, r, map[string]string{"id": group.ID, "ver": "v1"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("merging members that disagree answered %d, want 422", w.Code)
+	}
+}
+
+func TestAddMembersInEveryInputFormat(t *testing.T) {
+	ts, _ := newTestService(t)
+	ctx := context.Background()
+
+	group, err := ts.store.CreateGroup(ctx, &cs.Group{Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: map[string]string{"env": "prod"}, Entries: map[string]string{"k": "v"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": group.ID, "ver": "v1"}
+
+	tests := []struct {
+		contentType string
+		body        string
+		env         string
+		status      int
+	}{
+		{"application/json", `[{"labels": {"env": "json"}, "entries": {"k": "v"}}]`, "json", http.StatusCreated},
+		{"application/yaml", "- labels: {env: yaml}\n  entries: {k: v}\n", "yaml", http.StatusCreated},
+		{"application/toml", "[[configs]]\nlabels = {env = \"toml\"}\nentries = {k = \"v\"}\n", "toml", http.StatusCreated},
+		{"application/json", `[{"labels": {"env": "x"}, "entries": {"k": "v"}, "extra": 1}]`, "", http.StatusBadRequest},
+		{"text/plain", `env=x`, "", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/group/"+group.ID+"/v1/config", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+
+		w := serve(ts.addConfigToGroupHandler, r, vars)
+		if w.Code != test.status {
+			t.Errorf("%s %q answered %d, want %d", test.contentType, test.body, w.Code, test.status)
+			continue
+		}
+		if test.env == "" {
+			continue
+		}
+		configs, err := ts.store.FindLabels(ctx, group.ID, "v1", map[string]string{"env": test.env})
+		if err != nil || len(configs) != 1 {
+			t.Errorf("%s: found %d members labelled %s: %v", test.contentType, len(configs), test.env, err)
+		}
+	}
+}
+
+func TestRetriedWriteIsReplayed(t *testing.T) {
+	ts, _ := newTestService(t)
+
+	create := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/config/", strings.NewReader(`{"version": "v1", "entries": {"k": "v"}}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Idempotency-Key", "key-1")
+		return serve(ts.createConfigHandler, r, nil)
+	}
+
+	first := create()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("write answered %d: %s", first.Code, first.Body)
+	}
+	again := create()
+	if again.Code != first.Code || again.Body.String() != first.Body.String() || again.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("retry answered %d %q, want %d %q", again.Code, again.Body, first.Code, first.Body)
+	}
+
+	// Once the config is gone, the retry can't be answered like the write.
+	var body struct {
+		Resource *cs.Config `json:"resource"`
+	}
+	if err := json.Unmarshal(first.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.DeleteConfig(context.Background(), body.Resource.ID, "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if w := create(); w.Code != http.StatusConflict {
+		t.Errorf("retry after the config was deleted answered %d, want %d", w.Code, http.StatusConflict)
+	}
+}
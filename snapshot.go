@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+// snapshotResource names snapshots in the changes recorded under an
+// idempotency key.
+const snapshotResource = "snapshot"
+
+// Snapshots are kept as files in a local directory, newest last by name, and
+// only the newest retention of them survive a save. Names carry the time
+// down to the nanosecond, so that snapshots taken in the same second don't
+// replace each other.
+const (
+	snapshotPrefix    = "snapshot-"
+	snapshotExt       = ".snap"
+	snapshotTimestamp = "20060102T150405.000000000Z"
+)
+
+var errSnapshotName = errors.New("Invalid snapshot name")
+
+type snapshotDir struct {
+	path      string
+	retention int
+}
+
+type snapshotInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newSnapshotDir() (*snapshotDir, error) {
+	path := os.Getenv("SNAPSHOT_DIR")
+	if path == "" {
+		path = "snapshots"
+	}
+
+	retention := 10
+	if value := os.Getenv("SNAPSHOT_RETENTION"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid SNAPSHOT_RETENTION %q", value)
+		}
+		retention = n
+	}
+
+	return &snapshotDir{path: path, retention: retention}, nil
+}
+
+// save takes a backend snapshot, writes it next to the existing ones and
+// prunes those past the retention count. It never replaces a snapshot; if
+// one with the same name exists, save fails.
+func (d *snapshotDir) save(ctx context.Context, store *cs.ConfigStore) (*snapshotInfo, error) {
+	span := tracer.StartSpanFromContext(ctx, "saveSnapshot")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	err := os.MkdirAll(d.path, 0755)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotTimestamp) + snapshotExt
+	tmp, err := os.CreateTemp(d.path, ".tmp-"+name)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = store.SaveSnapshot(childCtx, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// Unlike a rename, a link fails if the name is taken.
+	err = os.Link(tmp.Name(), filepath.Join(d.path, name))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	snapshot, err := d.find(name)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	snapshots, err := d.list()
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The new snapshot is kept even if the clock makes it sort first.
+	older := []*snapshotInfo{}
+	for _, s := range snapshots {
+		if s.Name != name {
+			older = append(older, s)
+		}
+	}
+	for len(older) >= d.retention {
+		err = os.Remove(filepath.Join(d.path, older[0].Name))
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		older = older[1:]
+	}
+
+	return snapshot, nil
+}
+
+// list returns the stored snapshots, oldest first.
+func (d *snapshotDir) list() ([]*snapshotInfo, error) {
+	entries, err := os.ReadDir(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*snapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []*snapshotInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !validSnapshotName(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, &snapshotInfo{
+			Name:      entry.Name(),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().UTC(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+// find returns the named snapshot.
+func (d *snapshotDir) find(name string) (*snapshotInfo, error) {
+	if !validSnapshotName(name) {
+		return nil, errSnapshotName
+	}
+
+	info, err := os.Stat(filepath.Join(d.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, cs.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, cs.ErrNotFound
+	}
+
+	return &snapshotInfo{Name: name, Size: info.Size(), CreatedAt: info.ModTime().UTC()}, nil
+}
+
+// remove deletes the named snapshot.
+func (d *snapshotDir) remove(name string) error {
+	if !validSnapshotName(name) {
+		return errSnapshotName
+	}
+	return os.Remove(filepath.Join(d.path, name))
+}
+
+// restore replaces the backend state with the named snapshot.
+func (d *snapshotDir) restore(ctx context.Context, store *cs.ConfigStore, name string) (*cs.RestoreMarker, error) {
+	span := tracer.StartSpanFromContext(ctx, "restoreSnapshot")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if !validSnapshotName(name) {
+		return nil, errSnapshotName
+	}
+
+	f, err := os.Open(filepath.Join(d.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, cs.ErrNotFound
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	defer f.Close()
+
+	return store.RestoreSnapshot(childCtx, name, f)
+}
+
+// validSnapshotName also keeps names from escaping the snapshot directory.
+func validSnapshotName(name string) bool {
+	return strings.HasPrefix(name, snapshotPrefix) &&
+		strings.HasSuffix(name, snapshotExt) &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+func (ts *Service) createSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createSnapshotHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling create snapshot at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	// Retries are answered before a snapshot is taken for them.
+	requestId := idempotencyKey(req)
+	if ts.replayRequest(ctx, w, requestId) {
+		return
+	}
+
+	snapshot, err := ts.snapshots.save(ctx, ts.store)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not take snapshot")
+		return
+	}
+
+	// A snapshot isn't taken in a store transaction, so the key is only
+	// reserved once it is, and a snapshot taken for a retry that raced the
+	// first attempt is dropped.
+	err = ts.store.ReserveRequest(cs.WithRequestId(ctx, requestId), &cs.Event{Resource: snapshotResource, ID: snapshot.Name, Operation: cs.EventCreate})
+	if err != nil {
+		if rerr := ts.snapshots.remove(snapshot.Name); rerr != nil {
+			log.Printf("removing retried snapshot %s: %v", snapshot.Name, rerr)
+		}
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not take snapshot")
+		return
+	}
+
+	renderCreated(ctx, w, snapshotLocation(snapshot.Name), snapshot, requestId)
+}
+
+func (ts *Service) getSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getSnapshotHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get snapshot at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	snapshot, err := ts.snapshots.find(mux.Vars(req)["name"])
+	if errors.Is(err, errSnapshotName) {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+	if errors.Is(err, cs.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Snapshot not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not find snapshot")
+		return
+	}
+
+	renderJSON(ctx, w, snapshot, "")
+}
+
+// snapshotLocation returns the path a snapshot is served at.
+func snapshotLocation(name string) string {
+	return "/admin/snapshot/" + url.PathEscape(name)
+}
+
+func (ts *Service) getSnapshotsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getSnapshotsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get snapshots at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	snapshots, err := ts.snapshots.list()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not list snapshots")
+		return
+	}
+
+	renderJSON(ctx, w, snapshots, "")
+}
+
+func (ts *Service) restoreSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreSnapshotHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling restore snapshot at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	name := mux.Vars(req)["name"]
+	marker, err := ts.snapshots.restore(ctx, ts.store, name)
+	if errors.Is(err, errSnapshotName) {
+		writeProblem(w, http.StatusBadRequest, codeMalformed, err.Error())
+		return
+	}
+	if errors.Is(err, cs.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Snapshot not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not restore snapshot")
+		return
+	}
+
+	setRestoredFrom(marker)
+	renderJSON(ctx, w, marker, "")
+}
+
+// recordRestoredFrom exposes the snapshot the backend was last restored from,
+// including restores done before this instance started.
+func (ts *Service) recordRestoredFrom() {
+	marker, err := ts.store.RestoredFrom(context.Background())
+	if err != nil {
+		log.Printf("reading restore marker: %v", err)
+		return
+	}
+	if marker != nil {
+		setRestoredFrom(marker)
+	}
+}
+
+// snapshotCommand runs "snapshot save|list|restore <name>" against the
+// backend without starting the HTTP server.
+func snapshotCommand(args []string) error {
+	usage := errors.New("usage: snapshot save | snapshot list | snapshot restore <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	store, err := cs.New()
+	if err != nil {
+		return err
+	}
+
+	dir, err := newSnapshotDir()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "save" && len(args) == 1:
+		snapshot, err := dir.save(ctx, store)
+		if err != nil {
+			return err
+		}
+		fmt.Println(snapshot.Name)
+	case args[0] == "list" && len(args) == 1:
+		snapshots, err := dir.list()
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			fmt.Printf("%s\t%d\t%s\n", snapshot.Name, snapshot.Size, snapshot.CreatedAt.Format(time.RFC3339))
+		}
+	case args[0] == "restore" && len(args) == 2:
+		marker, err := dir.restore(ctx, store, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored %s at %s\n", marker.Snapshot, marker.RestoredAt.Format(time.RFC3339))
+	default:
+		return usage
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestSnapshotsTakenTogetherAreKept(t *testing.T) {
+	ts, _ := newTestService(t)
+	d := &snapshotDir{path: t.TempDir(), retention: 2}
+
+	var saved []string
+	for i := 0; i < 3; i++ {
+		snapshot, err := d.save(context.Background(), ts.store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, snapshot.Name)
+	}
+
+	snapshots, err := d.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	if !reflect.DeepEqual(names, saved[1:]) {
+		t.Errorf("kept %v of %v, want the newest 2", names, saved)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ts, _ := newTestService(t)
+	d := &snapshotDir{path: t.TempDir(), retention: 2}
+	ctx := context.Background()
+
+	config, err := ts.store.CreateConfig(ctx, &cs.Config{Version: "v1", Entries: cs.Entries{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := d.save(ctx, ts.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.DeleteConfig(ctx, config.ID, "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	marker, err := d.restore(ctx, ts.store, snapshot.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.Snapshot != snapshot.Name {
+		t.Errorf("restore marked %q, want %q", marker.Snapshot, snapshot.Name)
+	}
+	if _, err := ts.store.FindConf(ctx, config.ID, "v1"); err != nil {
+		t.Errorf("config deleted after the snapshot is still missing: %v", err)
+	}
+
+	if _, err := d.restore(ctx, ts.store, "../"+snapshot.Name); err != errSnapshotName {
+		t.Errorf("restore outside the directory: %v, want %v", err, errSnapshotName)
+	}
+}
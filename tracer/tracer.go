@@ -10,6 +10,7 @@ import (
 	"github.com/uber/jaeger-client-go/config"
 	jaegerlog "github.com/uber/jaeger-client-go/log"
 	"github.com/uber/jaeger-lib/metrics"
+	"google.golang.org/grpc/metadata"
 	"io"
 	"net/http"
 )
@@ -78,6 +79,22 @@ func StartSpanFromRequest(spanName string, tracer opentracing.Tracer, r *http.Re
 	return tracer.StartSpan(spanName, ext.RPCServerOption(spanCtx))
 }
 
+// StartSpanFromIncomingContext extracts the parent span context from the
+// metadata of an inbound gRPC call and starts a new child span if there is a
+// parent span.
+func StartSpanFromIncomingContext(spanName string, tracer opentracing.Tracer, ctx context.Context) opentracing.Span {
+	carrier := opentracing.TextMapCarrier{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		if len(v) > 0 {
+			carrier[k] = v[0]
+		}
+	}
+
+	spanCtx, _ := tracer.Extract(opentracing.TextMap, carrier)
+	return tracer.StartSpan(spanName, ext.RPCServerOption(spanCtx))
+}
+
 func StartSpanFromContext(ctx context.Context, spanName string) opentracing.Span {
 	span, _ := opentracing.StartSpanFromContext(ctx, spanName)
 	return span
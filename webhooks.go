@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Deliveries are retried with exponential backoff, starting at
+// webhookBackoff and doubling up to webhookMaxBackoff, and become dead
+// letters after webhookAttempts attempts.
+const (
+	webhookAttempts   = 6
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+	webhookTimeout    = 10 * time.Second
+)
+
+// webhookDispatcher delivers the events of the store to the webhooks
+// registered for them. Events are queued as pending deliveries in the store
+// first, and sent from there. A pending delivery holds its attempts and when
+// it is due next, so that retries outlive a restart.
+type webhookDispatcher struct {
+	store  *cs.ConfigStore
+	client *http.Client
+
+	mu sync.Mutex
+	// attempts holds the number of attempts started on each pending
+	// delivery, so that a listing from before an attempt was saved doesn't
+	// start it again.
+	attempts map[string]int
+}
+
+func newWebhookDispatcher(store *cs.ConfigStore) *webhookDispatcher {
+	// Deliveries only ever connect to the address of the webhook, also when
+	// redirected, and the address is checked after the name is resolved.
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: checkWebhookAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &webhookDispatcher{
+		store:    store,
+		client:   &http.Client{Timeout: webhookTimeout, Transport: transport},
+		attempts: make(map[string]int),
+	}
+}
+
+// errBlockedAddress is returned for webhooks on the host the server runs on
+// or on its link, which include cloud metadata services.
+var errBlockedAddress = errors.New("webhook address is loopback, link-local or unspecified")
+
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// checkWebhookAddress is the dialer control function of deliveries. It
+// refuses connections to blocked addresses.
+func checkWebhookAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+// dispatch is registered as a store listener. It queues a delivery of the
+// event to every webhook registered for it, and the event counts as taken
+// over once all of them are queued. A delivery is named after the webhook
+// and the event, so an event dispatched again isn't queued twice.
+func (d *webhookDispatcher) dispatch(event *cs.Event) error {
+	ctx := context.Background()
+
+	hooks, err := d.store.FindWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if !webhookMatches(hook, event) {
+			continue
+		}
+
+		id := uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("%s/%d", hook.ID, event.Seq)))
+		err := d.store.QueueDelivery(ctx, &cs.Delivery{
+			ID:        id.String(),
+			Webhook:   hook.ID,
+			Event:     event,
+			Status:    cs.Pending,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run keeps sending the pending deliveries of the store as they become
+// due, each from a goroutine of its own, until ctx is done.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	var index uint64
+	wait := cs.MaxWait
+	for ctx.Err() == nil {
+		deliveries, next, err := d.store.PendingDeliveries(ctx, index, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("listing pending webhook deliveries: %v", err)
+			time.Sleep(time.Second)
+			index = 0
+			continue
+		}
+
+		wait = d.startDue(deliveries)
+
+		// The index can go backwards, e.g. after a snapshot is restored.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// startDue starts an attempt on each of the deliveries that is due, and
+// returns how long it is until the next one becomes due, at most
+// cs.MaxWait.
+func (d *webhookDispatcher) startDue(deliveries []*cs.Delivery) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	wait := cs.MaxWait
+	listed := make(map[string]bool, len(deliveries))
+	for _, delivery := range deliveries {
+		listed[delivery.ID] = true
+		if delivery.Attempts < d.attempts[delivery.ID] {
+			continue
+		}
+
+		if due := delivery.NextAttempt.Sub(now); due > 0 {
+			if due < wait {
+				wait = due
+			}
+			continue
+		}
+
+		d.attempts[delivery.ID] = delivery.Attempts + 1
+		go d.attempt(delivery)
+	}
+
+	for id := range d.attempts {
+		if !listed[id] {
+			delete(d.attempts, id)
+		}
+	}
+	return wait
+}
+
+// forget lets the next listing start an attempt on a delivery whose last
+// attempt couldn't be saved.
+func (d *webhookDispatcher) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.attempts, id)
+}
+
+// webhookMatches reports whether hook is registered for the resource event
+// is about, webhooks with no config or group receiving every event.
+func webhookMatches(hook *cs.Webhook, event *cs.Event) bool {
+	switch {
+	case hook.Config != "":
+		return event.Resource == cs.ResourceConfig && event.ID == hook.Config
+	case hook.Group != "":
+		return event.Resource == cs.ResourceGroup && event.ID == hook.Group
+	}
+	return true
+}
+
+// attempt sends delivery once and saves the outcome in the delivery
+// history. A failed delivery is due again after a backoff that doubles with
+// every attempt, and becomes a dead letter once the attempts run out.
+func (d *webhookDispatcher) attempt(delivery *cs.Delivery) {
+	ctx := context.Background()
+
+	// Deleting a webhook removes its pending deliveries as well.
+	hook, err := d.store.FindWebhook(ctx, delivery.Webhook)
+	if err != nil {
+		if !errors.Is(err, cs.ErrNotFound) {
+			log.Printf("reading webhook %s: %v", delivery.Webhook, err)
+		}
+		d.forget(delivery.ID)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.StatusCode, delivery.Error = 0, ""
+
+	status, err := d.send(hook, delivery)
+	delivery.StatusCode = status
+	delivery.UpdatedAt = time.Now().UTC()
+
+	switch {
+	case err == nil:
+		delivery.Status = cs.Delivered
+	case delivery.Attempts >= webhookAttempts:
+		delivery.Status = cs.Dead
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = cs.Failed
+		delivery.Error = err.Error()
+		delivery.NextAttempt = delivery.UpdatedAt.Add(webhookDelay(delivery.Attempts))
+	}
+	webhookDeliveries.WithLabelValues(delivery.Status).Inc()
+
+	err = d.store.SaveDelivery(ctx, delivery)
+	if err != nil {
+		if !errors.Is(err, cs.ErrConflict) {
+			log.Printf("saving webhook delivery %s: %v", delivery.ID, err)
+		}
+		d.forget(delivery.ID)
+	}
+}
+
+// webhookDelay returns how long to wait after the given number of failed
+// attempts.
+func webhookDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}
+
+// send posts the event of delivery to the webhook. The signature covers the
+// time of sending as well as the body, so receivers can reject requests
+// replayed after a while. Any response other than 2xx counts as a failure.
+func (d *webhookDispatcher) send(hook *cs.Webhook, delivery *cs.Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", hook.ID)
+	req.Header.Set("X-Delivery-Id", delivery.ID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Signature-256", "sha256="+sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with secret.
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func decodeWebhookBody(ctx context.Context, r io.Reader) (*cs.Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeWebhookBody")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var body struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+		Config string `json:"config"`
+		Group  string `json:"group"`
+	}
+	if err := dec.Decode(&body); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", cs.ErrValidation)
+	}
+	// Names are only resolved when delivering, where the address is checked
+	// again.
+	host := u.Hostname()
+	if ip := net.ParseIP(host); strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") || (ip != nil && blockedIP(ip)) {
+		return nil, fmt.Errorf("%w: url must not point at a loopback, link-local or unspecified address", cs.ErrValidation)
+	}
+	if body.Config != "" && body.Group != "" {
+		return nil, fmt.Errorf("%w: a webhook is either for a config or for a group", cs.ErrValidation)
+	}
+
+	return &cs.Webhook{URL: body.URL, Secret: body.Secret, Config: body.Config, Group: body.Group}, nil
+}
+
+func (ts *Service) createWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createWebhookHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling create webhook at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	requestId := idempotencyKey(req)
+
+	hook, err := decodeWebhookBody(ctx, req.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if hook.Secret == "" {
+		hook.Secret, err = newSecret()
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, codeInternal, "Could not create webhook")
+			return
+		}
+	}
+
+	hook, err = ts.store.CreateWebhook(cs.WithRequestId(ctx, requestId), hook)
+	if err != nil {
+		if ts.replayRequest(ctx, w, requestId) {
+			return
+		}
+		writeStoreError(w, err, "Could not create webhook")
+		return
+	}
+
+	// The secret is only ever shown when the webhook is created, or when
+	// the request creating it is retried.
+	renderCreated(ctx, w, webhookLocation(hook.ID), hook, requestId)
+}
+
+// webhookLocation returns the path a webhook is served at.
+func webhookLocation(id string) string {
+	return "/webhook/" + url.PathEscape(id)
+}
+
+func (ts *Service) getWebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getWebhooksHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get webhooks at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	hooks, err := ts.store.FindWebhooks(ctx)
+	if err != nil {
+		writeStoreError(w, err, "Could not list webhooks")
+		return
+	}
+
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+	renderJSON(ctx, w, hooks, "")
+}
+
+func (ts *Service) getWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getWebhookHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get webhook at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	hook, err := ts.store.FindWebhook(ctx, mux.Vars(req)["id"])
+	if err != nil {
+		writeStoreError(w, err, "Could not get webhook")
+		return
+	}
+
+	hook.Secret = ""
+	renderJSON(ctx, w, hook, "")
+}
+
+func (ts *Service) delWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delWebhookHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete webhook at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	err := ts.store.DeleteWebhook(ctx, mux.Vars(req)["id"])
+	if err != nil {
+		writeStoreError(w, err, "Could not delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ts *Service) getDeliveriesHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getDeliveriesHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get webhook deliveries at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	if _, err := ts.store.FindWebhook(ctx, id); err != nil {
+		writeStoreError(w, err, "Could not get webhook")
+		return
+	}
+
+	deliveries, err := ts.store.FindDeliveries(ctx, id)
+	if err != nil {
+		writeStoreError(w, err, "Could not list deliveries")
+		return
+	}
+
+	renderJSON(ctx, w, deliveries, "")
+}
+
+func (ts *Service) getDeadLettersHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getDeadLettersHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get webhook dead letters at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	if _, err := ts.store.FindWebhook(ctx, id); err != nil {
+		writeStoreError(w, err, "Could not get webhook")
+		return
+	}
+
+	deliveries, err := ts.store.FindDeadLetters(ctx, id)
+	if err != nil {
+		writeStoreError(w, err, "Could not list dead letters")
+		return
+	}
+
+	renderJSON(ctx, w, deliveries, "")
+}
+
+// redeliverHandler takes a dead letter off the list and delivers it again
+// as a new delivery with a fresh set of attempts.
+func (ts *Service) redeliverHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("redeliverHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling webhook redelivery at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	id := mux.Vars(req)["id"]
+	hook, err := ts.store.FindWebhook(ctx, id)
+	if err != nil {
+		writeStoreError(w, err, "Could not get webhook")
+		return
+	}
+
+	delivery, err := ts.store.RedeliverDeadLetter(ctx, hook.ID, mux.Vars(req)["delivery"])
+	if err != nil {
+		writeStoreError(w, err, "Could not redeliver")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	renderJSON(ctx, w, delivery, "")
+}
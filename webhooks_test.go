@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestWebhookURLs(t *testing.T) {
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"url": "https://example.com/hook"}`, true},
+		{`{"url": "http://10.0.0.1:8080/hook", "config": "a"}`, true},
+		{`{"url": "ftp://example.com/hook"}`, false},
+		{`{"url": "/hook"}`, false},
+		{`{"url": "http://localhost/hook"}`, false},
+		{`{"url": "http://LOCALHOST./hook"}`, false},
+		{`{"url": "http://127.0.0.1/hook"}`, false},
+		{`{"url": "http://[::1]/hook"}`, false},
+		{`{"url": "http://169.254.169.254/latest/meta-data"}`, false},
+		{`{"url": "http://0.0.0.0/hook"}`, false},
+		{`{"url": "https://example.com/hook", "config": "a", "group": "g"}`, false},
+	}
+
+	for _, test := range tests {
+		_, err := decodeWebhookBody(context.Background(), strings.NewReader(test.body))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: %v", test.body, err)
+		}
+	}
+}
+
+func TestDeliveriesRefuseBlockedAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	// The URL is a name that only resolves to a blocked address when the
+	// delivery is sent.
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	d := newWebhookDispatcher(nil)
+	_, err := d.send(&cs.Webhook{URL: url, Secret: "s"}, &cs.Delivery{ID: "d", Event: &cs.Event{}})
+	if !errors.Is(err, errBlockedAddress) {
+		t.Errorf("delivery to %s answered %v, want %v", url, err, errBlockedAddress)
+	}
+	if called {
+		t.Error("delivery reached a loopback address")
+	}
+}
+
+func TestSignatureCoversTimestamp(t *testing.T) {
+	body := []byte(`{"seq": 1}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := sign("secret", "1700000000", body); got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+	if sign("secret", "1700000001", body) == want {
+		t.Error("a later timestamp has the same signature")
+	}
+}
+
+func TestWebhookDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, webhookBackoff},
+		{2, 2 * webhookBackoff},
+		{4, 8 * webhookBackoff},
+		{100, webhookMaxBackoff},
+	}
+
+	for _, test := range tests {
+		if delay := webhookDelay(test.attempts); delay != test.delay {
+			t.Errorf("delay after %d attempts is %v, want %v", test.attempts, delay, test.delay)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+	tracer "github.com/dekeract10/ARS-projekat/tracer"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsWriteWait  = 10 * time.Second
+)
+
+// wsMessage is sent both ways over a subscription socket. Clients send
+// "subscribe" and "unsubscribe" messages naming the subscription with ID;
+// the server answers a subscribe with a "snapshot" of the current state and
+// then sends an "event" for every matching change, along with the changed
+// config or group as it is when the event is sent. Deltas of groups hold the
+// members the selector matches, like snapshots.
+type wsMessage struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Config   string `json:"config,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Selector string `json:"selector,omitempty"`
+
+	Configs []*cs.Config `json:"configs,omitempty"`
+	Groups  []*cs.Group  `json:"groups,omitempty"`
+	Event   *cs.Event    `json:"event,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkOrigin,
+}
+
+// checkOrigin accepts same-origin requests and those from the origins
+// listed in WS_ORIGINS, separated by commas.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range strings.Split(os.Getenv("WS_ORIGINS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && (allowed == "*" || allowed == origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ts *Service) subscribeHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("subscribeHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling subscribe at %s\n", req.URL.Path)),
+	)
+
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		tracer.LogError(span, err)
+		return
+	}
+	defer conn.Close()
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	// Events are taken before any snapshot is read, so a change can only be
+	// seen twice, never missed. Past events aren't replayed.
+	_, events := ts.events.subscribe(^uint64(0))
+	defer ts.events.unsubscribe(events)
+
+	messages := make(chan *wsMessage)
+	done := make(chan struct{})
+	go readMessages(conn, messages, done)
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	subs := make(map[string]*eventFilter)
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case msg := <-messages:
+			err = ts.handleMessage(ctx, conn, subs, msg)
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber dropped"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+			// The changed resource is read once, for the first
+			// subscription the event matches.
+			var configs []*cs.Config
+			var groups []*cs.Group
+			read := false
+			for id, filter := range subs {
+				if !filter.match(event) {
+					continue
+				}
+				if !read {
+					configs, groups = ts.changed(ctx, event)
+					read = true
+				}
+
+				msg := &wsMessage{Type: "event", ID: id, Event: event, Configs: configs, Groups: selectMembers(groups, filter.selector)}
+				if err = writeMessage(conn, msg); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return
+		}
+	}
+}
+
+// readMessages passes the messages received on conn to messages until the
+// connection fails, then closes done.
+func readMessages(conn *websocket.Conn, messages chan<- *wsMessage, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		msg := &wsMessage{}
+		if err := conn.ReadJSON(msg); err != nil {
+			return
+		}
+
+		select {
+		case messages <- msg:
+		case <-time.After(wsPongWait):
+			return
+		}
+	}
+}
+
+func (ts *Service) handleMessage(ctx context.Context, conn *websocket.Conn, subs map[string]*eventFilter, msg *wsMessage) error {
+	span := tracer.StartSpanFromContext(ctx, "handleMessage")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	switch msg.Type {
+	case "subscribe":
+		filter, err := subscriptionFilter(msg)
+		if err != nil {
+			return writeMessage(conn, &wsMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+		}
+
+		snapshot, err := ts.snapshot(childCtx, filter)
+		if err != nil {
+			return writeMessage(conn, &wsMessage{Type: "error", ID: msg.ID, Error: err.Error()})
+		}
+
+		subs[msg.ID] = filter
+		snapshot.Type = "snapshot"
+		snapshot.ID = msg.ID
+		return writeMessage(conn, snapshot)
+	case "unsubscribe":
+		delete(subs, msg.ID)
+		return writeMessage(conn, &wsMessage{Type: "unsubscribed", ID: msg.ID})
+	default:
+		return writeMessage(conn, &wsMessage{Type: "error", ID: msg.ID, Error: "Unknown message type"})
+	}
+}
+
+// subscriptionFilter returns the filter of a subscribe message. It names a
+// config or a group, optionally a version of it, and for groups a label
+// selector their members have to match. A selector alone subscribes to the
+// members it matches in every group.
+func subscriptionFilter(msg *wsMessage) (*eventFilter, error) {
+	if msg.ID == "" {
+		return nil, errors.New("Subscription needs an id")
+	}
+	if msg.Config != "" && msg.Group != "" {
+		return nil, errors.New("Subscription needs either a config or a group")
+	}
+	if msg.Config == "" && msg.Group == "" && msg.Selector == "" {
+		return nil, errors.New("Subscription needs a config, a group or a selector")
+	}
+	if msg.Selector != "" && msg.Config != "" {
+		return nil, errors.New("Selector needs a group or no config")
+	}
+	if msg.Version != "" && msg.Config == "" && msg.Group == "" {
+		return nil, errors.New("Version needs a config or a group")
+	}
+
+	filter := &eventFilter{config: msg.Config, group: msg.Group, version: msg.Version}
+	if msg.Selector != "" {
+		selector, err := parseSelector(msg.Selector)
+		if err != nil {
+			return nil, errors.New("Invalid selector")
+		}
+		filter.selector = selector
+	}
+
+	return filter, nil
+}
+
+// snapshot returns the current state of what filter subscribes to.
+func (ts *Service) snapshot(ctx context.Context, filter *eventFilter) (*wsMessage, error) {
+	span := tracer.StartSpanFromContext(ctx, "snapshot")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if filter.config != "" {
+		if filter.version != "" {
+			config, err := ts.store.FindConf(childCtx, filter.config, filter.version)
+			if err != nil {
+				return nil, err
+			}
+			return &wsMessage{Configs: []*cs.Config{config}}, nil
+		}
+
+		configs, err := ts.store.FindConfVersions(childCtx, filter.config)
+		if err != nil {
+			return nil, err
+		}
+		return &wsMessage{Configs: configs}, nil
+	}
+
+	if filter.group == "" {
+		groups, err := ts.store.FindGroups(childCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only groups with matching members are part of the state.
+		var matched []*cs.Group
+		for _, group := range selectMembers(groups, filter.selector) {
+			if len(group.Configs) != 0 {
+				matched = append(matched, group)
+			}
+		}
+		return &wsMessage{Groups: matched}, nil
+	}
+
+	var groups []*cs.Group
+	if filter.version != "" {
+		group, err := ts.store.FindGroup(childCtx, filter.group, filter.version)
+		if err != nil {
+			return nil, err
+		}
+		groups = []*cs.Group{group}
+	} else {
+		var err error
+		groups, err = ts.store.FindGroupVersions(childCtx, filter.group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &wsMessage{Groups: selectMembers(groups, filter.selector)}, nil
+}
+
+// changed returns the config or group an event reports a change of, as it is
+// now. Nothing is returned for deletions and resets, or if the resource is
+// gone by the time the event is sent.
+func (ts *Service) changed(ctx context.Context, event *cs.Event) ([]*cs.Config, []*cs.Group) {
+	span := tracer.StartSpanFromContext(ctx, "changed")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if event.Operation == cs.EventDelete || event.Operation == cs.EventReset {
+		return nil, nil
+	}
+
+	switch event.Resource {
+	case cs.ResourceConfig:
+		config, err := ts.store.FindConf(childCtx, event.ID, event.Version)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, nil
+		}
+		return []*cs.Config{config}, nil
+	case cs.ResourceGroup:
+		group, err := ts.store.FindGroup(childCtx, event.ID, event.Version)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, nil
+		}
+		return nil, []*cs.Group{group}
+	}
+	return nil, nil
+}
+
+// selectMembers returns groups holding only the members that carry every
+// label of selector. The groups passed in are left as they are, since they
+// may be sent to several subscriptions.
+func selectMembers(groups []*cs.Group, selector map[string]string) []*cs.Group {
+	if len(selector) == 0 {
+		return groups
+	}
+
+	selected := make([]*cs.Group, 0, len(groups))
+	for _, group := range groups {
+		g := *group
+		g.Configs = nil
+		for _, config := range group.Configs {
+			if containsLabels(config.Labels, selector) {
+				g.Configs = append(g.Configs, config)
+			}
+		}
+		selected = append(selected, &g)
+	}
+	return selected
+}
+
+func writeMessage(conn *websocket.Conn, msg *wsMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return conn.WriteJSON(msg)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cs "github.com/dekeract10/ARS-projekat/configstore"
+)
+
+func TestSubscriptionFilter(t *testing.T) {
+	tests := []struct {
+		msg *wsMessage
+		ok  bool
+	}{
+		{&wsMessage{ID: "1", Config: "a"}, true},
+		{&wsMessage{ID: "1", Group: "g", Version: "v1", Selector: "env=prod"}, true},
+		{&wsMessage{ID: "1", Selector: "env=prod"}, true},
+		{&wsMessage{Config: "a"}, false},
+		{&wsMessage{ID: "1"}, false},
+		{&wsMessage{ID: "1", Config: "a", Group: "g"}, false},
+		{&wsMessage{ID: "1", Config: "a", Selector: "env=prod"}, false},
+		{&wsMessage{ID: "1", Version: "v1", Selector: "env=prod"}, false},
+	}
+
+	for _, test := range tests {
+		_, err := subscriptionFilter(test.msg)
+		if (err == nil) != test.ok {
+			t.Errorf("%+v: %v", test.msg, err)
+		}
+	}
+}
+
+func TestSelectorOnlySnapshot(t *testing.T) {
+	ts, _ := newTestService(t)
+	ctx := context.Background()
+
+	prod := map[string]string{"env": "prod"}
+	dev := map[string]string{"env": "dev"}
+	withProd, err := ts.store.CreateGroup(ctx, &cs.Group{Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: prod, Entries: cs.Entries{"k": "a"}},
+		{Labels: dev, Entries: cs.Entries{"k": "b"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.CreateGroup(ctx, &cs.Group{Version: "v1", Configs: []*cs.GroupConfig{
+		{Labels: dev, Entries: cs.Entries{"k": "c"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := subscriptionFilter(&wsMessage{ID: "1", Selector: "env=prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ts.snapshot(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshot.Groups) != 1 || snapshot.Groups[0].ID != withProd.ID {
+		t.Fatalf("snapshot holds %d groups, want only %s", len(snapshot.Groups), withProd.ID)
+	}
+	if members := snapshot.Groups[0].Configs; len(members) != 1 || members[0].Entries["k"] != "a" {
+		t.Errorf("snapshot holds members %v, want the prod one", members)
+	}
+
+	// The stored group keeps all of its members.
+	group, err := ts.store.FindGroup(ctx, withProd.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Configs) != 2 {
+		t.Errorf("group has %d members after the snapshot, want 2", len(group.Configs))
+	}
+}
+
+func TestEventsCarryTheChangedResource(t *testing.T) {
+	ts, _ := newTestService(t)
+	ctx := context.Background()
+
+	config, err := ts.store.CreateConfig(ctx, &cs.Config{Version: "v1", Entries: cs.Entries{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs, groups := ts.changed(ctx, &cs.Event{Resource: cs.ResourceConfig, ID: config.ID, Version: "v1", Operation: cs.EventUpdate})
+	if len(configs) != 1 || configs[0].Entries["k"] != "v" || len(groups) != 0 {
+		t.Errorf("update carries configs %v and groups %v", configs, groups)
+	}
+
+	configs, groups = ts.changed(ctx, &cs.Event{Resource: cs.ResourceConfig, ID: config.ID, Version: "v1", Operation: cs.EventDelete})
+	if len(configs) != 0 || len(groups) != 0 {
+		t.Errorf("delete carries configs %v and groups %v", configs, groups)
+	}
+}